@@ -51,6 +51,15 @@ func main() {
 	rec.RetrieveGameMetadataTo(buf)
 	fmt.Println(buf.String())
 
+	fmt.Println("--- Final metadata information ---")
+	buf.Reset()
+	if rec.HasFinalGameMetadata() {
+		rec.RetrieveFinalGameMetadataTo(buf)
+		fmt.Println(buf.String())
+	} else {
+		fmt.Println("Not available")
+	}
+
 	fmt.Println("--- Chunk information ---")
 	buf.Reset()
 	rec.RetrieveFirstChunkInfo().WriteTo(buf)
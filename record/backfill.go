@@ -0,0 +1,110 @@
+package record
+
+import (
+	"log"
+	"time"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+// The spectator endpoint continues to serve a game's data for a while after
+// the game has ended, which gives us a window to retrieve anything that was
+// missed while recording.
+const backfillAttempts = 6
+
+var backfillRetryWait = time.Second * 10
+
+// MissingData returns the chunk IDs and key frame IDs which should be in
+// a complete recording, according to the last chunk info stored in the
+// recording, but are not stored in the recording.
+func MissingData(rec *recording.Recording) ([]int, []int) {
+	return missingData(rec, rec.RetrieveLastChunkInfo())
+}
+
+// missingData returns the chunk IDs and key frame IDs which should be in
+// a complete recording described by last, but are not stored in rec.
+func missingData(rec *recording.Recording,
+	last recording.ChunkInfo) ([]int, []int) {
+	var chunks []int
+	for i := 1; i <= last.EndStartupChunk; i++ {
+		if !rec.HasChunk(i) {
+			chunks = append(chunks, i)
+		}
+	}
+
+	start := last.StartGameChunk
+	if start <= last.EndStartupChunk {
+		start = last.EndStartupChunk + 1
+	}
+
+	for i := start; i <= last.CurrentChunk; i++ {
+		if !rec.HasChunk(i) {
+			chunks = append(chunks, i)
+		}
+	}
+
+	var keyFrames []int
+	for i := 1; i <= last.CurrentKeyFrame; i++ {
+		if !rec.HasKeyFrame(i) {
+			keyFrames = append(keyFrames, i)
+		}
+	}
+
+	return chunks, keyFrames
+}
+
+// backfill retries the retrieval of every chunk and key frame that is
+// missing from the recording after the game has ended. It returns whether
+// or not the recording still has gaps afterwards.
+func (r *recorder) backfill() bool {
+	last := r.recording.RetrieveLastChunkInfo()
+	chunks, keyFrames := missingData(r.recording, last)
+
+	for attempt := 0; attempt < backfillAttempts; attempt++ {
+		if len(chunks) == 0 && len(keyFrames) == 0 {
+			break
+		}
+
+		if attempt > 0 {
+			time.Sleep(backfillRetryWait)
+		}
+
+		if showDebug {
+			log.Println("backfill attempt", attempt+1, "missing chunks:",
+				chunks, "missing key frames:", keyFrames)
+		}
+
+		b := r.newBatch()
+		for _, id := range chunks {
+			b.chunk(id)
+		}
+		for _, id := range keyFrames {
+			b.keyFrame(id)
+		}
+		chunks, keyFrames = b.wait()
+	}
+
+	if len(chunks) > 0 || len(keyFrames) > 0 {
+		return true
+	}
+
+	first := r.recording.RetrieveFirstChunkInfo()
+	if first.CurrentChunk == first.StartGameChunk {
+		return false
+	}
+
+	// The recording started part way through the game, but the start of
+	// the game has since been backfilled, so playback can begin from the
+	// start of the game.
+	first.CurrentChunk = first.StartGameChunk
+	first.NextChunk = first.StartGameChunk
+	first.CurrentKeyFrame = 1
+	if err := r.recording.StoreFirstChunkInfo(first); err != nil {
+		if showDebug {
+			log.Println("backfill failed to store first chunk info:", err)
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package record
+
+import (
+	"testing"
+	"time"
+)
+
+// The paths of a chunk and a key frame from before the first chunk info
+// which is served by the fake spectator server.
+const (
+	fakeChunkPath = "/observer-mode/rest/consumer/getGameDataChunk/" +
+		"TEST1/1/5/token"
+	fakeKeyFramePath = "/observer-mode/rest/consumer/getKeyFrame/" +
+		"TEST1/1/2/token"
+)
+
+func useShortBackfillRetryWait(t *testing.T) {
+	oldWait := backfillRetryWait
+	backfillRetryWait = time.Millisecond
+	t.Cleanup(func() {
+		backfillRetryWait = oldWait
+	})
+}
+
+// TestBackfill records a game which has already ended, so the data from
+// before the first chunk info is only retrieved by backfill, and fails the
+// first downloads of some of it.
+func TestBackfill(t *testing.T) {
+	useShortBackfillRetryWait(t)
+
+	failing := newFailingDownloads(2, fakeChunkPath, fakeKeyFramePath)
+	server := newFakeSpectator(t, failing)
+	defer server.Close()
+	registerFakePlatform(t, server)
+
+	rec, cleanUp := newTestRecording(t)
+	defer cleanUp()
+
+	if err := Record("TEST1", "1", "key", rec); err != nil {
+		t.Fatal(err)
+	}
+
+	checkComplete(t, rec)
+
+	for _, path := range []string{fakeChunkPath, fakeKeyFramePath} {
+		if n := failing.count(path); n != 3 {
+			t.Error(path, "was requested", n, "times")
+		}
+	}
+}
+
+// TestBackfillAttempts fails the downloads for longer than backfill retries
+// them, which leaves the recording incomplete.
+func TestBackfillAttempts(t *testing.T) {
+	useShortBackfillRetryWait(t)
+
+	failing := newFailingDownloads(backfillAttempts+1, fakeChunkPath,
+		fakeKeyFramePath)
+	server := newFakeSpectator(t, failing)
+	defer server.Close()
+	registerFakePlatform(t, server)
+
+	rec, cleanUp := newTestRecording(t)
+	defer cleanUp()
+
+	if err := Record("TEST1", "1", "key", rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.IsComplete() {
+		t.Error("recording with missing data is complete")
+	}
+
+	chunks, keyFrames := MissingData(rec)
+	if len(chunks) != 1 || chunks[0] != 5 || len(keyFrames) != 1 ||
+		keyFrames[0] != 2 {
+		t.Error("recording is missing chunks", chunks, "and key frames",
+			keyFrames)
+	}
+
+	for _, path := range []string{fakeChunkPath, fakeKeyFramePath} {
+		if n := failing.count(path); n != backfillAttempts {
+			t.Error(path, "was requested", n, "times")
+		}
+	}
+
+	// Playback still begins from the first chunk that was recorded, as the
+	// start of the game could not be backfilled.
+	if first := rec.RetrieveFirstChunkInfo(); first.CurrentChunk !=
+		fakeLastChunk {
+		t.Error("first chunk info starts at", first.CurrentChunk)
+	}
+}
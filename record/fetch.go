@@ -0,0 +1,178 @@
+package record
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+// metadataCacheTime is how long a Fetcher caches the game metadata of a
+// game for.
+const metadataCacheTime = time.Second * 30
+
+// A Fetcher retrieves the data of a game in progress from the spectator
+// endpoint on demand, so that a recording can be used as a read-through
+// cache of the spectator endpoint. Chunks and key frames are stored into
+// the recording as they are retrieved, while the game metadata and chunk
+// info are cached in memory, as they change while the game is in progress.
+// Concurrent requests for the same data are only retrieved once. A Fetcher
+// is safe for concurrent use.
+type Fetcher struct {
+	platformURL string
+	platform    string
+	gameID      string
+
+	mutex           *sync.Mutex
+	inflight        map[string]*fetchCall
+	chunkInfo       recording.ChunkInfo
+	chunkInfoExpiry time.Time
+	metadata        []byte
+	metadataExpiry  time.Time
+}
+
+// fetchCall is a retrieval in progress that other requests for the same
+// data wait on.
+type fetchCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// NewFetcher returns a new Fetcher for a game. ErrUnknownPlatform enclosed
+// in a *RecordingError is returned if the platform has not been registered.
+func NewFetcher(platform, gameID string) (*Fetcher, error) {
+	platformInfo, found := LookupPlatform(platform)
+	if !found {
+		return nil, newError("new fetcher", ErrUnknownPlatform)
+	}
+
+	return &Fetcher{
+		platformURL: platformInfo.SpectatorURL,
+		platform:    platform,
+		gameID:      gameID,
+		mutex:       new(sync.Mutex),
+		inflight:    make(map[string]*fetchCall),
+	}, nil
+}
+
+// do runs fn unless a call with the same key is already in progress, in
+// which case it waits for that call and returns its result instead.
+func (f *Fetcher) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	f.mutex.Lock()
+	if call, found := f.inflight[key]; found {
+		f.mutex.Unlock()
+		<-call.done
+		return call.data, call.err
+	}
+
+	call := &fetchCall{done: make(chan struct{})}
+	f.inflight[key] = call
+	f.mutex.Unlock()
+
+	call.data, call.err = fn()
+
+	f.mutex.Lock()
+	delete(f.inflight, key)
+	f.mutex.Unlock()
+	close(call.done)
+
+	return call.data, call.err
+}
+
+func (f *Fetcher) recorder(rec *recording.Recording) *recorder {
+	return &recorder{
+		recording:   rec,
+		platformURL: f.platformURL,
+		platform:    f.platform,
+		gameID:      f.gameID,
+	}
+}
+
+// LastChunkInfo returns the latest chunk info of the game. The chunk info
+// is cached until the spectator endpoint expects it to be updated.
+func (f *Fetcher) LastChunkInfo() (recording.ChunkInfo, error) {
+	f.mutex.Lock()
+	if time.Now().Before(f.chunkInfoExpiry) {
+		info := f.chunkInfo
+		f.mutex.Unlock()
+		return info, nil
+	}
+	f.mutex.Unlock()
+
+	_, err := f.do("chunk info", func() ([]byte, error) {
+		info, err := f.recorder(nil).retrieveLastChunkInfo()
+		if err != nil {
+			return nil, err
+		}
+
+		f.mutex.Lock()
+		f.chunkInfo = info
+		f.chunkInfoExpiry = time.Now().Add(
+			time.Duration(info.NextUpdate) * time.Millisecond)
+		f.mutex.Unlock()
+		return nil, nil
+	})
+	if err != nil {
+		return recording.ChunkInfo{}, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.chunkInfo, nil
+}
+
+// GameMetadata returns the game metadata of the game as it was returned
+// by the spectator endpoint. The metadata is cached for a short period.
+func (f *Fetcher) GameMetadata() ([]byte, error) {
+	f.mutex.Lock()
+	if time.Now().Before(f.metadataExpiry) {
+		data := f.metadata
+		f.mutex.Unlock()
+		return data, nil
+	}
+	f.mutex.Unlock()
+
+	return f.do("metadata", func() ([]byte, error) {
+		_, data, err := f.recorder(nil).retrieveMetadata()
+		if err != nil {
+			return nil, err
+		}
+
+		f.mutex.Lock()
+		f.metadata = data
+		f.metadataExpiry = time.Now().Add(metadataCacheTime)
+		f.mutex.Unlock()
+		return data, nil
+	})
+}
+
+// ChunkTo writes a chunk to w. If the chunk is missing from the recording,
+// it is retrieved from the spectator endpoint and stored into the
+// recording first.
+func (f *Fetcher) ChunkTo(rec *recording.Recording, id int,
+	w io.Writer) (int, error) {
+	if _, err := f.do("chunk "+strconv.Itoa(id), func() ([]byte, error) {
+		return nil, f.recorder(rec).storeChunk(id)
+	}); err != nil {
+		return 0, err
+	}
+
+	return rec.RetrieveChunkTo(id, w)
+}
+
+// KeyFrameTo writes a key frame to w. If the key frame is missing from the
+// recording, it is retrieved from the spectator endpoint and stored into
+// the recording first.
+func (f *Fetcher) KeyFrameTo(rec *recording.Recording, id int,
+	w io.Writer) (int, error) {
+	if _, err := f.do("key frame "+strconv.Itoa(id), func() ([]byte, error) {
+		return nil, f.recorder(rec).storeKeyFrame(id)
+	}); err != nil {
+		return 0, err
+	}
+
+	return rec.RetrieveKeyFrameTo(id, w)
+}
@@ -0,0 +1,233 @@
+package record
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Platform describes a platform (shard) of League of Legends and where its
+// services can be reached.
+type Platform struct {
+	// ID is the platform ID, such as NA1 or EUW1.
+	ID string `json:"id"`
+	// SpectatorURL is the base URL of the spectator endpoint, without a
+	// trailing slash.
+	SpectatorURL string `json:"spectator_url"`
+	// APIHost is the host of the Riot API for the platform. It may also be
+	// a full base URL (such as http://127.0.0.1:8080) to use a different
+	// scheme or port.
+	APIHost string `json:"api_host"`
+	// Region is the short name of the region to display to users.
+	Region string `json:"region"`
+	// RoutingHost is the host of the regional routing Riot API which
+	// serves the platform, used for APIs which are not served by platform
+	// hosts such as the account API. Like APIHost, it may also be a full
+	// base URL.
+	RoutingHost string `json:"routing_host"`
+}
+
+// DefaultPlatforms are the platforms that are registered by default.
+var DefaultPlatforms = []Platform{
+	{"NA1", "http://spectator.na.lol.riotgames.com:80", "na1.api.riotgames.com", "na", "americas.api.riotgames.com"},
+	{"OC1", "http://spectator.oc1.lol.riotgames.com:80", "oc1.api.riotgames.com", "oce", "americas.api.riotgames.com"},
+	{"EUN1", "http://spectator.eu.lol.riotgames.com:80", "eun1.api.riotgames.com", "eune", "europe.api.riotgames.com"},
+	{"EUW1", "http://spectator.euw1.lol.riotgames.com:80", "euw1.api.riotgames.com", "euw", "europe.api.riotgames.com"},
+	{"KR", "http://spectator.kr.lol.riotgames.com:80", "kr.api.riotgames.com", "kr", "asia.api.riotgames.com"},
+	{"BR1", "http://spectator.br.lol.riotgames.com:80", "br1.api.riotgames.com", "br", "americas.api.riotgames.com"},
+	{"LA1", "http://spectator.la1.lol.riotgames.com:80", "la1.api.riotgames.com", "lan", "americas.api.riotgames.com"},
+	{"LA2", "http://spectator.la2.lol.riotgames.com:80", "la2.api.riotgames.com", "las", "americas.api.riotgames.com"},
+	{"RU", "http://spectator.ru.lol.riotgames.com:80", "ru.api.riotgames.com", "ru", "europe.api.riotgames.com"},
+	{"TR1", "http://spectator.tr.lol.riotgames.com:80", "tr1.api.riotgames.com", "tr", "europe.api.riotgames.com"},
+	{"PBE1", "http://spectator.pbe1.lol.riotgames.com:80", "pbe1.api.riotgames.com", "pbe", "americas.api.riotgames.com"},
+}
+
+// ErrInvalidPlatform is returned by RegisterPlatform if the platform is
+// missing its ID or has an invalid spectator URL.
+var ErrInvalidPlatform = errors.New("invalid platform")
+
+var (
+	platforms      = make(map[string]Platform)
+	platformOrder  []string
+	platformsMutex = new(sync.RWMutex)
+)
+
+func init() {
+	for _, platform := range DefaultPlatforms {
+		if err := RegisterPlatform(platform); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterPlatform adds a platform to the registry of platforms, replacing
+// any existing platform with the same ID. Fields which are empty when
+// replacing a platform keep their existing values.
+func RegisterPlatform(platform Platform) error {
+	platformsMutex.Lock()
+	defer platformsMutex.Unlock()
+
+	existing, found := platforms[platform.ID]
+	platform, err := resolvePlatform(platform, existing, found)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		platformOrder = append(platformOrder, platform.ID)
+	}
+
+	platforms[platform.ID] = platform
+	return nil
+}
+
+// ResolvePlatforms returns the platforms which would be registered if
+// platforms were registered with RegisterPlatform, in order, to a registry
+// holding only the DefaultPlatforms. The registry is not modified, so the
+// result can be validated before it is used with SetPlatforms.
+func ResolvePlatforms(platforms []Platform) ([]Platform, error) {
+	all := append(append([]Platform{}, DefaultPlatforms...), platforms...)
+	resolved, order, err := resolvePlatforms(all)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Platform, 0, len(order))
+	for _, id := range order {
+		result = append(result, resolved[id])
+	}
+
+	return result, nil
+}
+
+// SetPlatforms replaces the registry of platforms with newPlatforms, which
+// are usually the result of ResolvePlatforms. Platforms which are not in
+// newPlatforms are no longer registered. The registry is not modified if
+// any of the platforms are invalid.
+func SetPlatforms(newPlatforms []Platform) error {
+	resolved, order, err := resolvePlatforms(newPlatforms)
+	if err != nil {
+		return err
+	}
+
+	platformsMutex.Lock()
+	defer platformsMutex.Unlock()
+
+	platforms = resolved
+	platformOrder = order
+	return nil
+}
+
+// resolvePlatforms registers platforms in order to a new registry, and
+// returns the registry and the order of its platforms.
+func resolvePlatforms(list []Platform) (map[string]Platform, []string,
+	error) {
+	resolved := make(map[string]Platform)
+	var order []string
+
+	for _, platform := range list {
+		existing, found := resolved[platform.ID]
+		platform, err := resolvePlatform(platform, existing, found)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !found {
+			order = append(order, platform.ID)
+		}
+
+		resolved[platform.ID] = platform
+	}
+
+	return resolved, order, nil
+}
+
+// resolvePlatform validates a platform and fills in its empty fields, from
+// the existing platform with the same ID if found is true, or otherwise
+// from the defaults.
+func resolvePlatform(platform, existing Platform, found bool) (Platform,
+	error) {
+	if platform.ID == "" {
+		return Platform{}, newError("register platform", ErrInvalidPlatform)
+	}
+
+	if found {
+		if platform.SpectatorURL == "" {
+			platform.SpectatorURL = existing.SpectatorURL
+		}
+		if platform.APIHost == "" {
+			platform.APIHost = existing.APIHost
+		}
+		if platform.Region == "" {
+			platform.Region = existing.Region
+		}
+		if platform.RoutingHost == "" {
+			platform.RoutingHost = existing.RoutingHost
+		}
+	}
+
+	platform.SpectatorURL = strings.TrimSuffix(platform.SpectatorURL, "/")
+	u, err := url.Parse(platform.SpectatorURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" {
+		return Platform{}, newError("register platform "+platform.ID,
+			ErrInvalidPlatform)
+	}
+
+	if platform.APIHost == "" {
+		platform.APIHost = strings.ToLower(platform.ID) + ".api.riotgames.com"
+	}
+
+	if platform.Region == "" {
+		platform.Region = strings.ToLower(platform.ID)
+	}
+
+	if platform.RoutingHost == "" {
+		platform.RoutingHost = "americas.api.riotgames.com"
+	}
+
+	return platform, nil
+}
+
+// LookupPlatform returns the registered platform with the specified ID.
+func LookupPlatform(id string) (Platform, bool) {
+	platformsMutex.RLock()
+	defer platformsMutex.RUnlock()
+
+	platform, found := platforms[id]
+	return platform, found
+}
+
+// Platforms returns all of the registered platforms in the order they were
+// registered.
+func Platforms() []Platform {
+	platformsMutex.RLock()
+	defer platformsMutex.RUnlock()
+
+	result := make([]Platform, 0, len(platformOrder))
+	for _, id := range platformOrder {
+		result = append(result, platforms[id])
+	}
+
+	return result
+}
+
+// APIURL returns the URL of a path on the platform's Riot API.
+func (p Platform) APIURL(path string) string {
+	if strings.Contains(p.APIHost, "://") {
+		return strings.TrimSuffix(p.APIHost, "/") + path
+	}
+
+	return "https://" + p.APIHost + path
+}
+
+// RoutingURL returns the URL of a path on the regional routing Riot API
+// which serves the platform.
+func (p Platform) RoutingURL(path string) string {
+	if strings.Contains(p.RoutingHost, "://") {
+		return strings.TrimSuffix(p.RoutingHost, "/") + path
+	}
+
+	return "https://" + p.RoutingHost + path
+}
@@ -0,0 +1,100 @@
+package record
+
+import (
+	"log"
+	"sync"
+)
+
+// downloadWorkers is the maximum number of chunks and key frames that are
+// downloaded at the same time for a single recording.
+const downloadWorkers = 4
+
+// downloadPool is a bounded pool of workers which run download jobs for a
+// recorder.
+type downloadPool struct {
+	jobs chan func()
+	wg   *sync.WaitGroup
+}
+
+func newDownloadPool(workers int) *downloadPool {
+	p := &downloadPool{
+		jobs: make(chan func()),
+		wg:   new(sync.WaitGroup),
+	}
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+
+	return p
+}
+
+// close stops the workers after all of the submitted jobs have completed.
+// No more jobs can be submitted after close is called.
+func (p *downloadPool) close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// fetchBatch is a group of downloads submitted to a recorder's pool that
+// can be waited on together.
+type fetchBatch struct {
+	recorder        *recorder
+	wg              *sync.WaitGroup
+	mutex           *sync.Mutex
+	failedChunks    []int
+	failedKeyFrames []int
+}
+
+func (r *recorder) newBatch() *fetchBatch {
+	return &fetchBatch{
+		recorder: r,
+		wg:       new(sync.WaitGroup),
+		mutex:    new(sync.Mutex),
+	}
+}
+
+func (b *fetchBatch) submit(id int, store func(int) error, failed *[]int) {
+	b.wg.Add(1)
+	b.recorder.pool.jobs <- func() {
+		defer b.wg.Done()
+
+		if err := store(id); err != nil {
+			if showDebug {
+				log.Println("failed to get", id, "error:", err)
+			}
+
+			b.mutex.Lock()
+			*failed = append(*failed, id)
+			b.mutex.Unlock()
+		}
+	}
+}
+
+// chunk queues the download of a chunk. It blocks until a worker is
+// available to accept it.
+func (b *fetchBatch) chunk(id int) {
+	b.submit(id, b.recorder.storeChunk, &b.failedChunks)
+}
+
+// keyFrame queues the download of a key frame. It blocks until a worker
+// is available to accept it.
+func (b *fetchBatch) keyFrame(id int) {
+	b.submit(id, b.recorder.storeKeyFrame, &b.failedKeyFrames)
+}
+
+// wait blocks until all of the downloads in the batch have completed, and
+// returns the chunk IDs and key frame IDs that failed to download.
+func (b *fetchBatch) wait() ([]int, []int) {
+	b.wg.Wait()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.failedChunks, b.failedKeyFrames
+}
@@ -12,26 +12,22 @@ import (
 	"github.com/1lann/lol-replay/recording"
 )
 
-var platformURLs = map[string]string{
-	"NA1":  "http://spectator.na.lol.riotgames.com:80",
-	"OC1":  "http://spectator.oc1.lol.riotgames.com:80",
-	"EUN1": "http://spectator.eu.lol.riotgames.com:80",
-	"EUW1": "http://spectator.euw1.lol.riotgames.com:80",
-	"KR":   "http://spectator.kr.lol.riotgames.com:80",
-	"BR1":  "http://spectator.br.lol.riotgames.com:80",
-	"LA1":  "http://spectator.la1.lol.riotgames.com:80",
-	"LA2":  "http://spectator.la2.lol.riotgames.com:80",
-	"RU":   "http://spectator.ru.lol.riotgames.com:80",
-	"TR1":  "http://spectator.tr.lol.riotgames.com:80",
-	"PBE1": "http://spectator.pbe1.lol.riotgames.com:80",
-}
-
+// recorder holds the state of a single recording. The fields are not
+// modified after the recorder is created, downloads are run through pool
+// and all writes to the recording are synchronized by the recording itself.
 type recorder struct {
 	recording   *recording.Recording
 	platformURL string
 	platform    string
 	gameID      string
-	gaps        bool
+	pool        *downloadPool
+
+	// resumption is the batch downloading data from before a recording
+	// was resumed, and resumptionQueued is closed once all of its
+	// downloads have been submitted. They are only accessed by the
+	// goroutine running Record.
+	resumption       *fetchBatch
+	resumptionQueued chan struct{}
 }
 
 var showDebug = os.Getenv("GLR_DEBUG") != ""
@@ -50,7 +46,7 @@ func init() {
 // recorded from the provided parameters.
 func Record(platform, gameID, encryptionKey string,
 	rec *recording.Recording) error {
-	url, found := platformURLs[platform]
+	platformInfo, found := LookupPlatform(platform)
 	if !found {
 		return newError("", ErrUnknownPlatform)
 	}
@@ -61,13 +57,15 @@ func Record(platform, gameID, encryptionKey string,
 	}
 
 	thisRecorder := &recorder{
-		platformURL: url,
+		platformURL: platformInfo.SpectatorURL,
 		recording:   rec,
 		platform:    platform,
 		gameID:      gameID,
-		gaps:        false,
+		pool:        newDownloadPool(downloadWorkers),
 	}
 
+	defer thisRecorder.close()
+
 	version, err := GetPlatformVersion(platform)
 	if err != nil {
 		return err
@@ -103,18 +101,49 @@ func Record(platform, gameID, encryptionKey string,
 		return err
 	}
 
-	info := thisRecorder.recording.RetrieveFirstChunkInfo()
-	if info.CurrentChunk != info.StartGameChunk {
-		thisRecorder.gaps = true
+	if err := thisRecorder.storeFinalMetadata(); err != nil {
+		if showDebug {
+			log.Println("storeFinalMetadata error:", err)
+		}
 	}
 
-	if !thisRecorder.gaps {
+	if showDebug {
+		log.Println("game ended, backfilling missing data")
+	}
+
+	thisRecorder.waitForResumption()
+
+	if gaps := thisRecorder.backfill(); !gaps {
 		thisRecorder.recording.DeclareComplete()
 	}
 
 	return nil
 }
 
+// close stops the download pool of the recorder. The pool must only be
+// closed once the resumption has finished submitting its downloads, as
+// submitting to a closed pool panics.
+func (r *recorder) close() {
+	if r.resumptionQueued != nil {
+		<-r.resumptionQueued
+	}
+
+	r.pool.close()
+}
+
+// waitForResumption blocks until the data from before a recording was
+// resumed has been downloaded, if the recording was resumed.
+func (r *recorder) waitForResumption() {
+	if r.resumption == nil {
+		return
+	}
+
+	// The batch can only be waited on once all of its downloads have been
+	// submitted, otherwise wait could return while some are still queuing.
+	<-r.resumptionQueued
+	r.resumption.wait()
+}
+
 func (r *recorder) getStartupFrames(meta metadata) error {
 	if showDebug {
 		log.Println("getting startup chunks to", meta.StartupChunk)
@@ -185,25 +214,43 @@ func (r *recorder) waitForFirstChunk() error {
 	return r.getStartupFrames(meta)
 }
 
+// storeFinalMetadata stores the game metadata again after the game has
+// ended, as the metadata retrieved at the start of the game does not
+// contain the final chunk IDs or the length of the game.
+func (r *recorder) storeFinalMetadata() error {
+	if r.recording.HasFinalGameMetadata() {
+		return nil
+	}
+
+	_, data, err := r.retrieveMetadata()
+	if err != nil {
+		return err
+	}
+
+	if err := r.recording.StoreFinalGameMetadata(
+		bytes.NewReader(data)); err != nil {
+		return newError("final metadata", err)
+	}
+
+	return nil
+}
+
 func (r *recorder) handleResumption(chunk recording.ChunkInfo) {
-	// Download as much previous data (as fast) as possible
-	go func() {
+	// Download as much previous data (as fast) as possible, in the
+	// background. Anything that fails is retried by backfill.
+	r.resumption = r.newBatch()
+	r.resumptionQueued = make(chan struct{})
+	go func(b *fetchBatch, queued chan struct{}) {
+		defer close(queued)
+
 		for i := chunk.CurrentChunk; i >= chunk.StartGameChunk; i-- {
-			if err := r.storeChunk(i); err != nil {
-				r.gaps = true
-				return
-			}
+			b.chunk(i)
 		}
-	}()
 
-	go func() {
 		for i := chunk.CurrentKeyFrame; i >= 1; i-- {
-			if err := r.storeKeyFrame(i); err != nil {
-				r.gaps = true
-				return
-			}
+			b.keyFrame(i)
 		}
-	}()
+	}(r.resumption, r.resumptionQueued)
 }
 
 func (r *recorder) storeChunksAndFrames(chunk recording.ChunkInfo, lastChunkID,
@@ -215,28 +262,31 @@ func (r *recorder) storeChunksAndFrames(chunk recording.ChunkInfo, lastChunkID,
 			"firstKeyFrame:", firstKeyFrame)
 	}
 
+	b := r.newBatch()
+
 	if chunk.CurrentChunk > lastChunkID {
 		for i := lastChunkID + 1; i <= chunk.CurrentChunk; i++ {
-			if err := r.storeChunk(i); err != nil {
-				return err
-			}
+			b.chunk(i)
 		}
 	}
 
 	if chunk.NextChunk < chunk.CurrentChunk && chunk.NextChunk > 0 {
-		if err := r.storeChunk(chunk.NextChunk); err != nil {
-			return err
-		}
+		b.chunk(chunk.NextChunk)
 	}
 
 	if chunk.CurrentKeyFrame > lastKeyFrame {
 		for i := lastKeyFrame + 1; i <= chunk.CurrentKeyFrame; i++ {
-			if err := r.storeKeyFrame(i); err != nil {
-				return err
-			}
+			b.keyFrame(i)
 		}
 	}
 
+	// Wait for the batch so that the chunk info stored afterwards never
+	// refers to data that is still being downloaded.
+	failedChunks, failedKeyFrames := b.wait()
+	if len(failedChunks) > 0 || len(failedKeyFrames) > 0 {
+		return newError("store chunks and frames", errIncompleteBatch)
+	}
+
 	return nil
 }
 
@@ -312,8 +362,12 @@ func (r *recorder) recordFrames(resumption bool) error {
 			firstChunkID = chunk.StartGameChunk
 		}
 
-		r.storeChunksAndFrames(chunk, lastChunkID, firstChunkID, lastKeyFrame,
-			firstKeyFrame)
+		// Missing chunks and key frames are retrieved after the game ends
+		// by backfill, so errors here are not fatal.
+		if err := r.storeChunksAndFrames(chunk, lastChunkID, firstChunkID,
+			lastKeyFrame, firstKeyFrame); err != nil && showDebug {
+			log.Println("storeChunksAndFrames error:", err)
+		}
 
 		if err := r.storeChunkInfo(firstChunkID, firstKeyFrame,
 			chunk); err != nil {
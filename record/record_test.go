@@ -0,0 +1,208 @@
+package record
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+// The fake spectator server serves a game which has already ended, so that
+// recordings of it finish without waiting for new chunks.
+const (
+	fakeEndStartupChunk = 2
+	fakeStartGameChunk  = 3
+	fakeLastChunk       = 8
+	fakeLastKeyFrame    = 3
+)
+
+// failingDownloads makes the fake spectator server fail the first requests
+// for selected chunks and key frames, and counts the requests for them.
+type failingDownloads struct {
+	mutex    *sync.Mutex
+	failures map[string]int
+	requests map[string]int
+}
+
+// newFailingDownloads fails the first failures requests for each path in
+// paths.
+func newFailingDownloads(failures int, paths ...string) *failingDownloads {
+	f := &failingDownloads{
+		mutex:    new(sync.Mutex),
+		failures: make(map[string]int),
+		requests: make(map[string]int),
+	}
+
+	for _, path := range paths {
+		f.failures[path] = failures
+	}
+
+	return f
+}
+
+// fail records a request for path, and returns whether it should fail.
+func (f *failingDownloads) fail(path string) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.requests[path]++
+	if f.failures[path] > 0 {
+		f.failures[path]--
+		return true
+	}
+
+	return false
+}
+
+// count returns the number of requests made for path.
+func (f *failingDownloads) count(path string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.requests[path]
+}
+
+func newFakeSpectator(t *testing.T,
+	failing *failingDownloads) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case strings.HasSuffix(path, "/version"):
+			fmt.Fprint(w, "1.2.3")
+		case strings.Contains(path, "/getGameMetaData/"):
+			fmt.Fprintf(w, `{"endStartupChunkId":%d,"lastChunkId":%d}`,
+				fakeEndStartupChunk, fakeLastChunk)
+		case strings.Contains(path, "/getLastChunkInfo/"):
+			fmt.Fprintf(w, `{"chunkId":%d,"nextAvailableChunk":0,`+
+				`"keyFrameId":%d,"nextChunkId":%d,"endStartupChunkId":%d,`+
+				`"startGameChunkId":%d,"endGameChunkId":%d,`+
+				`"duration":30000}`, fakeLastChunk, fakeLastKeyFrame,
+				fakeLastChunk, fakeEndStartupChunk, fakeStartGameChunk,
+				fakeLastChunk)
+		case strings.Contains(path, "/getGameDataChunk/"),
+			strings.Contains(path, "/getKeyFrame/"):
+			if failing.fail(path) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			fmt.Fprint(w, path)
+		default:
+			t.Error("unexpected request:", path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func newTestRecording(t *testing.T) (*recording.Recording, func()) {
+	file, err := ioutil.TempFile("", "record-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := recording.NewRecording(file)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		t.Fatal(err)
+	}
+
+	return rec, func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
+
+func registerFakePlatform(t *testing.T, server *httptest.Server) {
+	if err := RegisterPlatform(Platform{
+		ID:           "TEST1",
+		SpectatorURL: server.URL,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkComplete(t *testing.T, rec *recording.Recording) {
+	if !rec.IsComplete() {
+		t.Error("recording is not complete")
+	}
+
+	chunks, keyFrames := MissingData(rec)
+	if len(chunks) > 0 || len(keyFrames) > 0 {
+		t.Error("recording is missing chunks", chunks, "and key frames",
+			keyFrames)
+	}
+
+	if first := rec.RetrieveFirstChunkInfo(); first.CurrentChunk !=
+		fakeStartGameChunk {
+		t.Error("first chunk info starts at", first.CurrentChunk)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	server := newFakeSpectator(t, nil)
+	defer server.Close()
+	registerFakePlatform(t, server)
+
+	rec, cleanUp := newTestRecording(t)
+	defer cleanUp()
+
+	if err := Record("TEST1", "1", "key", rec); err != nil {
+		t.Fatal(err)
+	}
+
+	checkComplete(t, rec)
+
+	if info := rec.RetrieveGameInfo(); info.Platform != "TEST1" ||
+		info.GameID != "1" || info.EncryptionKey != "key" ||
+		info.Version != "1.2.3" {
+		t.Error("unexpected game info:", info)
+	}
+}
+
+// TestRecordResumption resumes recordings of a game which has already
+// ended, where every download of the resumption returns immediately. This
+// used to close the download pool while the resumption was still
+// submitting downloads to it.
+func TestRecordResumption(t *testing.T) {
+	server := newFakeSpectator(t, nil)
+	defer server.Close()
+	registerFakePlatform(t, server)
+
+	rec, cleanUp := newTestRecording(t)
+	defer cleanUp()
+
+	if err := Record("TEST1", "1", "key", rec); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := Record("TEST1", "1", "key", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkComplete(t, rec)
+}
+
+func TestUnknownPlatform(t *testing.T) {
+	rec, cleanUp := newTestRecording(t)
+	defer cleanUp()
+
+	err := Record("UNKNOWN", "1", "key", rec)
+	if recErr, ok := err.(*RecordingError); !ok ||
+		recErr.Err != ErrUnknownPlatform {
+		t.Error("expected unknown platform error, got:", err)
+	}
+}
@@ -23,6 +23,8 @@ var (
 	ErrUnknownPlatform = errors.New("unknown platform")
 )
 
+var errIncompleteBatch = errors.New("some downloads failed")
+
 func requestOnceURL(url string) (io.ReadCloser, error) {
 	resp, err := http.Get(url)
 	if err != nil {
@@ -85,21 +87,22 @@ type metadata struct {
 	LastChunk    int `json:"lastChunkId"`
 }
 
-// IsValidPlatform returns whether or not a platform is valid (i.e. has an
-// entry in the map of platforms and platform URLs).
+// IsValidPlatform returns whether or not a platform is valid (i.e. has been
+// registered with RegisterPlatform or is one of the DefaultPlatforms).
 func IsValidPlatform(platform string) bool {
-	_, found := platformURLs[platform]
+	_, found := LookupPlatform(platform)
 	return found
 }
 
 // GetPlatformVersion returns the current version of the specified platform.
 func GetPlatformVersion(platform string) (string, error) {
-	url, found := platformURLs[platform]
+	platformInfo, found := LookupPlatform(platform)
 	if !found {
 		return "", newError("get platform version", ErrUnknownPlatform)
 	}
 
-	resp, err := requestURLBytes(url + "/observer-mode/rest/consumer/version")
+	resp, err := requestURLBytes(platformInfo.SpectatorURL +
+		"/observer-mode/rest/consumer/version")
 	if err != nil {
 		return "", newError("get platform version", err)
 	}
@@ -144,7 +147,14 @@ func (r *recorder) storeChunk(frame int) error {
 		return newError("chunk", err)
 	}
 
+	defer resp.Close()
+
 	if err := r.recording.StoreChunk(frame, resp); err != nil {
+		if err == recording.ErrCannotModify {
+			// Stored concurrently by another download.
+			return nil
+		}
+
 		return newError("chunk", err)
 	}
 	return nil
@@ -170,7 +180,14 @@ func (r *recorder) storeKeyFrame(frame int) error {
 		return newError("key frame", err)
 	}
 
+	defer resp.Close()
+
 	if err := r.recording.StoreKeyFrame(frame, resp); err != nil {
+		if err == recording.ErrCannotModify {
+			// Stored concurrently by another download.
+			return nil
+		}
+
 		return newError("key frame", err)
 	}
 	return nil
@@ -0,0 +1,41 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// GameMetadata is the part of the game metadata from the spectator
+// endpoint that describes the bounds and length of the game.
+type GameMetadata struct {
+	GameEnded       bool `json:"gameEnded"`
+	GameLength      int  `json:"gameLength"`
+	StartGameChunk  int  `json:"startGameChunkId"`
+	EndStartupChunk int  `json:"endStartupChunkId"`
+	LastChunk       int  `json:"lastChunkId"`
+	LastKeyFrame    int  `json:"lastKeyFrameId"`
+	EndGameChunk    int  `json:"endGameChunkId"`
+	EndGameKeyFrame int  `json:"endGameKeyFrameId"`
+}
+
+// RetrieveFinalGameMetadata retrieves and decodes the game metadata that
+// was retrieved after the game ended. If the recording has no final game
+// metadata, ErrMissingData will be returned.
+func (r *Recording) RetrieveFinalGameMetadata() (GameMetadata, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	if _, err := r.RetrieveFinalGameMetadataTo(buf); err != nil {
+		return GameMetadata{}, err
+	}
+
+	var meta GameMetadata
+	if err := json.Unmarshal(buf.Bytes(), &meta); err != nil {
+		return GameMetadata{}, ErrCorruptRecording
+	}
+
+	return meta, nil
+}
@@ -42,6 +42,10 @@ type recordingHeader struct {
 	UserMetadata   segment
 	IsComplete     bool
 	LastWriteTime  time.Time
+	// FinalGameMetadata is the game metadata retrieved after the game has
+	// ended. It is only present in recordings that reached the end of
+	// the game.
+	FinalGameMetadata segment
 }
 
 // GameInfo represents meta information for a game required to play it back
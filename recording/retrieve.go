@@ -36,6 +36,15 @@ func (r *Recording) HasGameMetadata() bool {
 	return r.header.GameMetadata.Length > 0
 }
 
+// HasFinalGameMetadata returns whether or not the metadata of the game
+// retrieved after the game has ended has been written to the recording.
+func (r *Recording) HasFinalGameMetadata() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.header.FinalGameMetadata.Length > 0
+}
+
 // HasUserMetadata returns whether or not the user metadata has already been
 // written to the recording or not.
 func (r *Recording) HasUserMetadata() bool {
@@ -67,17 +76,26 @@ func (r *Recording) RetrieveUserMetadata(metadata interface{}) error {
 
 // RetrieveGameInfo retrieves the recorded game's basic information.
 func (r *Recording) RetrieveGameInfo() GameInfo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	return r.header.Info
 }
 
 // IsComplete returns whether or not the recording has been declared as
 // being complete or not.
 func (r *Recording) IsComplete() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	return r.header.IsComplete
 }
 
 // LastWriteTime returns the last time data was written to the recording.
 func (r *Recording) LastWriteTime() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	return r.header.LastWriteTime
 }
 
@@ -86,13 +104,27 @@ func (r *Recording) LastWriteTime() time.Time {
 // returned.
 func (r *Recording) RetrieveGameMetadataTo(w io.Writer) (int, error) {
 	r.mutex.Lock()
+	return r.retrieveSegmentTo(r.header.GameMetadata, w)
+}
+
+// RetrieveFinalGameMetadataTo retrieves the game metadata that was
+// retrieved after the game ended into w. The number of bytes written to w
+// and any errors that have occurred are returned. If the recording has no
+// final game metadata, ErrMissingData will be returned.
+func (r *Recording) RetrieveFinalGameMetadataTo(w io.Writer) (int, error) {
+	r.mutex.Lock()
+	return r.retrieveSegmentTo(r.header.FinalGameMetadata, w)
+}
 
-	if r.header.GameMetadata.Length <= 0 {
+// retrieveSegmentTo reads a segment into w. The recording's mutex must be
+// locked before calling retrieveSegmentTo, and will be unlocked by it.
+func (r *Recording) retrieveSegmentTo(seg segment, w io.Writer) (int, error) {
+	if seg.Length <= 0 {
 		r.mutex.Unlock()
 		return 0, ErrMissingData
 	}
 
-	if _, err := r.file.Seek(int64(r.header.GameMetadata.Position), 0); err != nil {
+	if _, err := r.file.Seek(int64(seg.Position), 0); err != nil {
 		r.mutex.Unlock()
 		return 0, err
 	}
@@ -103,7 +135,7 @@ func (r *Recording) RetrieveGameMetadataTo(w io.Writer) (int, error) {
 		bufferPool.Put(buf)
 	}()
 
-	if _, err := io.CopyN(buf, r.file, int64(r.header.GameMetadata.Length)); err != nil {
+	if _, err := io.CopyN(buf, r.file, int64(seg.Length)); err != nil {
 		r.mutex.Unlock()
 		return 0, err
 	}
@@ -111,7 +143,6 @@ func (r *Recording) RetrieveGameMetadataTo(w io.Writer) (int, error) {
 
 	written, err := buf.WriteTo(w)
 	return int(written), err
-
 }
 
 // RetrieveFirstChunkInfo retrieves the chunk info that should be returned
@@ -23,6 +23,9 @@ func (r *Recording) Unlock() {
 
 // DeclareComplete declares the recording as a complete recording.
 func (r *Recording) DeclareComplete() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	if r.header.IsComplete {
 		return nil
 	}
@@ -102,6 +105,36 @@ func (r *Recording) StoreGameMetadata(rd io.Reader) error {
 	return r.writeHeader()
 }
 
+// StoreFinalGameMetadata stores the game metadata retrieved after the game
+// has ended to the file. The final game metadata is read-only, and thus can
+// only be stored once.
+func (r *Recording) StoreFinalGameMetadata(rd io.Reader) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	if _, err := buf.ReadFrom(rd); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.header.FinalGameMetadata.Length > 0 {
+		return ErrCannotModify
+	}
+
+	seg, err := r.writeToStack(buf)
+	if err != nil {
+		return err
+	}
+
+	r.header.FinalGameMetadata = seg
+	return r.writeHeader()
+}
+
 // StoreFirstChunkInfo stores the chunk info that should be returned
 // first to the client.
 func (r *Recording) StoreFirstChunkInfo(chunkInfo ChunkInfo) error {
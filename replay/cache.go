@@ -0,0 +1,65 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+// Chunks and key frames never change once they have been recorded, so the
+// data of complete recordings can be cached indefinitely. The data of
+// recordings in progress is only cached briefly, in case the recording is
+// deleted and recorded again. Responses which depend on the playback
+// session, such as the chunk info, must never be cached, and neither must
+// the data of recordings marked as private by the Retriever, as shared
+// caches would keep serving it to clients which are no longer allowed to
+// play the recording.
+const (
+	completeCacheControl   = "public, max-age=31536000, immutable"
+	inProgressCacheControl = "public, max-age=60"
+	sessionCacheControl    = "no-store"
+	privateCacheControl    = "private, no-store"
+)
+
+var bufferPool = &sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// MarkPrivate marks the recording being retrieved with ctx as private, so
+// that none of its responses are cached. It should be called by a Retriever
+// for recordings which not everyone is allowed to play.
+func MarkPrivate(ctx context.Context) {
+	if private, ok := ctx.Value(privateContextKey).(*bool); ok {
+		*private = true
+	}
+}
+
+// serveData writes data from a recording with validators and cache headers,
+// so that it can be cached by clients, reverse proxies and CDNs. Conditional
+// and range requests are handled by http.ServeContent. tag identifies the
+// data within the recording, and is used to build the data's ETag. The
+// Cache-Control header set by retrieve for private recordings is kept.
+func serveData(w http.ResponseWriter, r *http.Request,
+	rec *recording.Recording, tag string, data []byte) {
+	info := rec.RetrieveGameInfo()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", "\""+info.Platform+"-"+info.GameID+"-"+tag+"-"+
+		strconv.FormatInt(info.RecordTime.Unix(), 36)+"\"")
+
+	switch {
+	case w.Header().Get("Cache-Control") == privateCacheControl:
+	case rec.IsComplete():
+		w.Header().Set("Cache-Control", completeCacheControl)
+	default:
+		w.Header().Set("Cache-Control", inProgressCacheControl)
+	}
+
+	http.ServeContent(w, r, "", rec.LastWriteTime(), bytes.NewReader(data))
+}
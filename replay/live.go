@@ -0,0 +1,65 @@
+package replay
+
+import (
+	"time"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+// liveTimeout is how long after its last write a recording that is not
+// complete is still considered to be in progress.
+const liveTimeout = time.Minute * 2
+
+// isLive returns whether or not a recording is still being recorded.
+func isLive(rec *recording.Recording) bool {
+	return !rec.IsComplete() && !rec.HasFinalGameMetadata() &&
+		time.Since(rec.LastWriteTime()) < liveTimeout
+}
+
+// delayedChunkInfo returns the chunk info for a recording in progress as
+// it was delay ago, so that viewers trail the recorder by delay. Chunks are
+// assumed to have been recorded one chunk duration apart, with the last
+// chunk recorded at the recording's last write time.
+func delayedChunkInfo(rec *recording.Recording,
+	delay time.Duration) recording.ChunkInfo {
+	first := rec.RetrieveFirstChunkInfo()
+	last := rec.RetrieveLastChunkInfo()
+
+	chunkDuration := time.Duration(last.Duration) * time.Millisecond
+	if chunkDuration <= 0 {
+		chunkDuration = 30 * time.Second
+	}
+
+	// The time the last chunk will be available to viewers.
+	lastAvailable := rec.LastWriteTime().Add(delay)
+	now := time.Now()
+
+	chunk := last.CurrentChunk
+	if wait := lastAvailable.Sub(now); wait > 0 {
+		chunk -= int((wait + chunkDuration - 1) / chunkDuration)
+	}
+
+	if chunk < first.CurrentChunk {
+		chunk = first.CurrentChunk
+	}
+
+	// The time the chunk after this one will be available to viewers.
+	nextAvailable := lastAvailable.Add(
+		time.Duration(chunk+1-last.CurrentChunk) * chunkDuration)
+	nextUpdate := nextAvailable.Sub(now)
+	if nextUpdate < time.Second {
+		nextUpdate = time.Second
+	}
+
+	info := last
+	info.CurrentChunk = chunk
+	info.NextChunk = chunk
+	info.EndGameChunk = 0
+	info.NextUpdate = int(nextUpdate / time.Millisecond)
+	info.CurrentKeyFrame = first.CurrentKeyFrame
+	if keyFrame, ok := nearestKeyFrame(rec, first, last, chunk); ok {
+		info.CurrentKeyFrame = keyFrame
+	}
+
+	return info
+}
@@ -0,0 +1,237 @@
+package replay
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+// Options for playback can be given to spectator clients as part of the
+// host they spectate from, as a series of path prefixes before PathHeader,
+// for example "example.com:9001/session/a1b2/start/15m". A request to
+// "/session/a1b2/start/15m" + PathHeader + "/..." is handled the same as a
+// request to PathHeader + "/...", but with the playback options applied.
+const (
+	// SessionPathHeader is the path prefix used to identify a playback
+	// session, followed by the session ID.
+	SessionPathHeader = "/session"
+	// StartPathHeader is the path prefix used to start playback part way
+	// through a game, followed by a start offset such as 15m (15 minutes
+	// into the game) or c40 (chunk 40).
+	StartPathHeader = "/start"
+	// GamePathHeader is the path prefix used to tell the replay router
+	// which game a client is going to play before the client requests it,
+	// followed by the platform and game ID separated by a dash, such as
+	// NA1-2345678901. It is used to report the game's version to the client.
+	GamePathHeader = "/game"
+)
+
+type contextKey int
+
+const (
+	optionsContextKey contextKey = iota
+	tokenContextKey
+	privateContextKey
+)
+
+type playbackOptions struct {
+	session     string
+	startMinute int
+	startChunk  int
+	region      string
+	gameID      string
+	token       string
+}
+
+// StartAtMinutePath returns the path prefix to start playback at a number
+// of minutes into the game.
+func StartAtMinutePath(minutes int) string {
+	return StartPathHeader + "/" + strconv.Itoa(minutes) + "m"
+}
+
+// StartAtChunkPath returns the path prefix to start playback at a chunk.
+func StartAtChunkPath(chunk int) string {
+	return StartPathHeader + "/c" + strconv.Itoa(chunk)
+}
+
+// GamePath returns the path prefix to tell the replay router which game a
+// client is going to play.
+func GamePath(region, gameID string) string {
+	return GamePathHeader + "/" + region + "-" + gameID
+}
+
+// IsReplayPath returns whether or not a request path should be handled by
+// the replay router.
+func IsReplayPath(path string) bool {
+	_, rest, ok := parsePlaybackPath(path)
+	return ok && strings.HasPrefix(rest, PathHeader+"/")
+}
+
+// parsePlaybackPath parses the playback options in front of PathHeader in
+// a path, and returns the options and the rest of the path.
+func parsePlaybackPath(path string) (playbackOptions, string, bool) {
+	var opts playbackOptions
+
+	for !strings.HasPrefix(path, PathHeader+"/") {
+		parts := strings.SplitN(path, "/", 4)
+		if len(parts) < 4 || parts[0] != "" || parts[2] == "" {
+			return playbackOptions{}, path, false
+		}
+
+		name, value := "/"+parts[1], parts[2]
+		path = "/" + parts[3]
+
+		switch name {
+		case SessionPathHeader:
+			opts.session = value
+		case StartPathHeader:
+			if !opts.parseStart(value) {
+				return playbackOptions{}, path, false
+			}
+		case TokenPathHeader:
+			opts.token = value
+		case GamePathHeader:
+			sep := strings.LastIndex(value, "-")
+			if sep <= 0 || sep == len(value)-1 {
+				return playbackOptions{}, path, false
+			}
+			opts.region, opts.gameID = value[:sep], value[sep+1:]
+		default:
+			return playbackOptions{}, path, false
+		}
+	}
+
+	return opts, path, true
+}
+
+func (o *playbackOptions) parseStart(value string) bool {
+	if strings.HasSuffix(value, "m") {
+		minute, err := strconv.Atoi(strings.TrimSuffix(value, "m"))
+		if err != nil || minute < 0 {
+			return false
+		}
+
+		o.startMinute = minute
+		o.startChunk = 0
+		return true
+	}
+
+	if strings.HasPrefix(value, "c") {
+		chunk, err := strconv.Atoi(strings.TrimPrefix(value, "c"))
+		if err != nil || chunk <= 0 {
+			return false
+		}
+
+		o.startChunk = chunk
+		o.startMinute = 0
+		return true
+	}
+
+	return false
+}
+
+// stripPlaybackOptions removes the playback options from a request's path,
+// and stores them in the request's context. Requests without playback
+// options are returned unmodified.
+func stripPlaybackOptions(r *http.Request) *http.Request {
+	if strings.HasPrefix(r.URL.Path, PathHeader+"/") {
+		return r
+	}
+
+	opts, rest, ok := parsePlaybackPath(r.URL.Path)
+	if !ok {
+		return r
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), optionsContextKey, opts))
+	u := *r.URL
+	u.Path = rest
+	u.RawPath = ""
+	r.URL = &u
+	return r
+}
+
+// requestOptions returns the playback options of a request.
+func requestOptions(r *http.Request) playbackOptions {
+	opts, _ := r.Context().Value(optionsContextKey).(playbackOptions)
+	return opts
+}
+
+// startChunkInfo returns the chunk info that makes the client start
+// playback from a start offset, at the nearest key frame at or before the
+// start offset. False is returned if the options have no start offset.
+func startChunkInfo(rec *recording.Recording,
+	opts playbackOptions) (recording.ChunkInfo, bool) {
+	if opts.startChunk <= 0 && opts.startMinute <= 0 {
+		return recording.ChunkInfo{}, false
+	}
+
+	first := rec.RetrieveFirstChunkInfo()
+	last := lastChunkInfo(rec)
+
+	chunk := opts.startChunk
+	if chunk <= 0 {
+		duration := first.Duration
+		if duration <= 0 {
+			duration = 30000
+		}
+
+		chunk = first.StartGameChunk + opts.startMinute*60000/duration
+	}
+
+	keyFrame, ok := nearestKeyFrame(rec, first, last, chunk)
+	if !ok {
+		return first, true
+	}
+
+	info := first
+	info.CurrentKeyFrame = keyFrame
+	info.CurrentChunk = chunkForKeyFrame(first, last, keyFrame)
+	info.NextChunk = info.CurrentChunk
+	return info, true
+}
+
+// Key frames are taken at a regular interval, so the key frame for a chunk
+// (and the chunk for a key frame) is found from the chunk and key frame
+// IDs of the first and last chunk info of a recording.
+
+// nearestKeyFrame returns the ID of the nearest recorded key frame at or
+// before a chunk. False is returned if it would be at or before the key
+// frame of the first chunk info.
+func nearestKeyFrame(rec *recording.Recording, first,
+	last recording.ChunkInfo, chunk int) (int, bool) {
+	if chunk <= first.CurrentChunk || last.CurrentChunk <= first.CurrentChunk ||
+		last.CurrentKeyFrame <= first.CurrentKeyFrame {
+		return 0, false
+	}
+
+	if chunk > last.CurrentChunk {
+		chunk = last.CurrentChunk
+	}
+
+	chunks := float64(last.CurrentChunk - first.CurrentChunk)
+	keyFrames := float64(last.CurrentKeyFrame - first.CurrentKeyFrame)
+	keyFrame := first.CurrentKeyFrame +
+		int(float64(chunk-first.CurrentChunk)*keyFrames/chunks)
+
+	for keyFrame > first.CurrentKeyFrame && !rec.HasKeyFrame(keyFrame) {
+		keyFrame--
+	}
+
+	if keyFrame <= first.CurrentKeyFrame {
+		return 0, false
+	}
+
+	return keyFrame, true
+}
+
+// chunkForKeyFrame returns the ID of the chunk a key frame was taken at.
+func chunkForKeyFrame(first, last recording.ChunkInfo, keyFrame int) int {
+	chunks := float64(last.CurrentChunk - first.CurrentChunk)
+	keyFrames := float64(last.CurrentKeyFrame - first.CurrentKeyFrame)
+	return first.CurrentChunk +
+		int(float64(keyFrame-first.CurrentKeyFrame)*chunks/keyFrames)
+}
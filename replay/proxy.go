@@ -0,0 +1,112 @@
+package replay
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/1lann/lol-replay/record"
+	"github.com/1lann/lol-replay/recording"
+)
+
+var errTooManyGames = errors.New("replay: too many proxied games")
+
+type proxyGame struct {
+	fetcher  *record.Fetcher
+	lastUsed time.Time
+}
+
+// fetcherStore holds the fetchers of the games that are being proxied.
+// Fetchers that have not been used for sessionTimeout are removed. It is
+// safe for concurrent use.
+type fetcherStore struct {
+	games map[string]*proxyGame
+	mutex *sync.Mutex
+}
+
+func newFetcherStore() *fetcherStore {
+	return &fetcherStore{
+		games: make(map[string]*proxyGame),
+		mutex: new(sync.Mutex),
+	}
+}
+
+// get returns the fetcher for a game, creating it if it does not exist.
+func (s *fetcherStore) get(region, gameID string) (*record.Fetcher, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for key, game := range s.games {
+		if now.Sub(game.lastUsed) > sessionTimeout {
+			delete(s.games, key)
+		}
+	}
+
+	if game, found := s.games[region+"_"+gameID]; found {
+		game.lastUsed = now
+		return game.fetcher, nil
+	}
+
+	if len(s.games) >= maxSessions {
+		return nil, errTooManyGames
+	}
+
+	fetcher, err := record.NewFetcher(region, gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.games[region+"_"+gameID] = &proxyGame{fetcher, now}
+	return fetcher, nil
+}
+
+// proxyFetcher returns the fetcher to use for a request if the request
+// should be proxied to the spectator endpoint, which is the case if the
+// proxy is enabled and the game is being recorded. Games without a
+// recording are never proxied, so that anonymous requests cannot make the
+// server retrieve arbitrary games from the spectator endpoint.
+func (rh *requestHandler) proxyFetcher(region, gameID string,
+	rec *recording.Recording) (*record.Fetcher, bool) {
+	if !rh.options.Proxy {
+		return nil, false
+	}
+
+	if rec.IsComplete() || rec.HasFinalGameMetadata() {
+		return nil, false
+	}
+
+	// Game IDs are always numeric, and are checked to prevent requests to
+	// arbitrary paths on the spectator endpoint.
+	if _, err := strconv.ParseInt(gameID, 10, 64); err != nil {
+		return nil, false
+	}
+
+	fetcher, err := rh.fetchers.get(region, gameID)
+	if err != nil {
+		return nil, false
+	}
+
+	return fetcher, true
+}
+
+// writeProxyError writes the response for an error that occurred while
+// retrieving data from the spectator endpoint.
+func writeProxyError(w http.ResponseWriter, err error, notFound string) {
+	if recErr, ok := err.(*record.RecordingError); ok &&
+		recErr.Err == record.ErrNotFound {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(notFound))
+		return
+	}
+
+	log.Println("proxy error:", err)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write([]byte("spectator endpoint unavailable"))
+}
@@ -2,17 +2,13 @@
 package replay
 
 import (
-	"fmt"
+	"bytes"
 	"log"
-	"net"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/1lann/lol-replay/record"
 	"github.com/1lann/lol-replay/recording"
-	"github.com/Clever/leakybucket"
-	memorybucket "github.com/Clever/leakybucket/memory"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -20,27 +16,32 @@ import (
 // endpoint.
 const PathHeader = "/observer-mode/rest/consumer"
 
-// A client is identified by a IP/gameID pair.
-// We want to identify a client because in order to start a spectating session from the
-// beginning, we need to "pretend" that the last available chunk is one of the first chunks.
-// Therefore if the client is new, we modify the behaviour of getLastChunkInfo.
-type client struct {
-	IP     string
-	gameID string
+// Options configures the behaviour of the router returned by NewRouter.
+type Options struct {
+	// LiveDelay is how far behind the recorder viewers of a recording that
+	// is still in progress are kept. If LiveDelay is zero, recordings in
+	// progress are served with all of the data that has been recorded.
+	LiveDelay time.Duration
+	// Proxy makes the router act as a read-through cache of the spectator
+	// endpoint for games that are being recorded. Data that is missing from
+	// the recording of a game in progress is retrieved from the spectator
+	// endpoint and stored into the recording before it is returned. Games
+	// in progress are served without LiveDelay when Proxy is enabled.
+	Proxy bool
+	// TokenKey is the key that tokens given to clients are signed with. If
+	// TokenKey is set, requests with a token that is invalid or has expired
+	// are rejected, and the claims of valid tokens are given to the
+	// Retriever, which decides whether or not a token is required.
+	TokenKey []byte
 }
 
-var bucketStore = memorybucket.New()
-
-// A Retriever provides a recording for a given game ID and region.
-// A nil recording should be returned if the recording does not exist.
-type Retriever func(region, gameId string) *recording.Recording
-
 type requestHandler struct {
 	retriever Retriever
-	// Track how often a client has been making requests, using the leaky
-	// bucket algorithm so that if the same client spectates again after a while,
-	// we consider them a new client.
-	newClientBuckets map[client]leakybucket.Bucket
+	options   Options
+	router    *httprouter.Router
+	sessions  *sessionStore
+	versions  *versionCache
+	fetchers  *fetcherStore
 }
 
 type httpWriterPipe struct {
@@ -68,32 +69,39 @@ func (p *httpWriterPipe) HasWritten() bool {
 	return p.hasWritten
 }
 
-func (rh requestHandler) version(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	version, err := record.GetPlatformVersion("OC1")
-	if err != nil {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("version unavailable"))
+func (rh *requestHandler) getGameMetadata(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Cache-Control", sessionCacheControl)
+
+	rec, fetcher, ok := rh.retrieve(w, r, ps)
+	if !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(version))
-	return
-}
+	rh.sessions.restart(requestSessionKey(r, ps.ByName("region"),
+		ps.ByName("id")))
+	rh.versions.remember(requestClientID(r), rec.RetrieveGameInfo().Version)
 
-func (rh requestHandler) getGameMetadata(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	rec := rh.retriever(ps.ByName("region"), ps.ByName("id"))
-	if rec == nil {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("game not found"))
-		return
+	if fetcher != nil {
+		data, err := fetcher.GameMetadata()
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+			return
+		}
+
+		// Fall back to the recorded metadata.
+	}
+
+	// Prefer the metadata retrieved after the game ended, as it contains
+	// the final chunk IDs and length of the game.
+	retrieveTo := rec.RetrieveGameMetadataTo
+	if rec.HasFinalGameMetadata() {
+		retrieveTo = rec.RetrieveFinalGameMetadataTo
 	}
 
 	pipe := newHTTPWriterPipe(w, "application/json")
-	_, err := rec.RetrieveGameMetadataTo(pipe)
+	_, err := retrieveTo(pipe)
 	if err != nil {
 		if pipe.HasWritten() {
 			log.Println("getGameMetadata silent error:", err)
@@ -116,58 +124,77 @@ func (rh requestHandler) getGameMetadata(w http.ResponseWriter, r *http.Request,
 	}
 }
 
-func (rh requestHandler) getLastChunkInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	rec := rh.retriever(ps.ByName("region"), ps.ByName("id"))
-	if rec == nil {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("game not found"))
+func (rh *requestHandler) getLastChunkInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Cache-Control", sessionCacheControl)
+
+	rec, fetcher, ok := rh.retrieve(w, r, ps)
+	if !ok {
 		return
 	}
 
+	if fetcher != nil {
+		info, err := fetcher.LastChunkInfo()
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			info.WriteTo(w)
+			return
+		}
+
+		// Fall back to the recorded chunk info.
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	// Identify the client by the IP/gameID tuple
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	c := client{
-		IP:     ip,
-		gameID: ps.ByName("id"),
+	if live, ok := rh.liveChunkInfo(rec); ok {
+		live.WriteTo(w)
+		return
 	}
 
-	if rh.newClientBuckets[c] == nil {
-		// A normal spectator client should make request to this endpoint once every 10 seconds.
-		// Therefore, we use the more conservative number of 3 here, meaning that a client is
-		// considered new if it hasn't made 3 requests in the last minute.
-		bucket, err := bucketStore.Create(
-			fmt.Sprintf("%s-%s", r.RemoteAddr, ps.ByName("id")),
-			3,
-			time.Minute,
-		)
-		if err != nil {
-			rec.RetrieveLastChunkInfo().WriteTo(w)
-			return
+	// If the session has just started, we "pretend" that the last available
+	// chunk is one of the first chunks, so that the spectator client starts
+	// playing from the beginning. Otherwise, we return the real last
+	// available chunk.
+	key := requestSessionKey(r, ps.ByName("region"), ps.ByName("id"))
+	if rh.sessions.get(key) == sessionStart {
+		if info, ok := startChunkInfo(rec, requestOptions(r)); ok {
+			info.WriteTo(w)
+		} else {
+			rec.RetrieveFirstChunkInfo().WriteTo(w)
 		}
-		rh.newClientBuckets[c] = bucket
+	} else {
+		lastChunkInfo(rec).WriteTo(w)
 	}
+}
 
-	// We try to figure out if the client is a new or not.  If the client is new, we "pretend"
-	// that the last available chunk is one of the first few chunks, so that the spectator client
-	// would start playing from the beginning.  Otherwise, we return the real last available chunk.
-	_, err := rh.newClientBuckets[c].Add(1)
+// lastChunkInfo returns the last chunk info of a recording. If the
+// recording has final game metadata, the end of the game is taken from it
+// as long as the recording has the data to play up to that point.
+func lastChunkInfo(rec *recording.Recording) recording.ChunkInfo {
+	info := rec.RetrieveLastChunkInfo()
+
+	meta, err := rec.RetrieveFinalGameMetadata()
 	if err != nil {
-		rec.RetrieveLastChunkInfo().WriteTo(w)
-	} else {
-		rec.RetrieveFirstChunkInfo().WriteTo(w)
+		return info
 	}
+
+	if meta.EndGameChunk > 0 && rec.HasChunk(meta.EndGameChunk) {
+		info.CurrentChunk = meta.EndGameChunk
+		info.NextChunk = meta.EndGameChunk
+		info.EndGameChunk = meta.EndGameChunk
+	}
+
+	if meta.EndGameKeyFrame > 0 && rec.HasKeyFrame(meta.EndGameKeyFrame) {
+		info.CurrentKeyFrame = meta.EndGameKeyFrame
+	}
+
+	return info
 }
 
-func (rh requestHandler) getGameDataChunk(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	rec := rh.retriever(ps.ByName("region"), ps.ByName("id"))
-	if rec == nil {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("game not found"))
+func (rh *requestHandler) getGameDataChunk(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rec, fetcher, ok := rh.retrieve(w, r, ps)
+	if !ok {
 		return
 	}
 
@@ -179,14 +206,39 @@ func (rh requestHandler) getGameDataChunk(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	pipe := newHTTPWriterPipe(w, "application/octet-stream")
-	_, err = rec.RetrieveChunkTo(chunk, pipe)
-	if err != nil {
-		if pipe.HasWritten() {
-			log.Println("getGameDataChunk silent error:", err)
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	if fetcher != nil {
+		if _, err := fetcher.ChunkTo(rec, chunk, buf); err != nil {
+			writeProxyError(w, err, "chunk not found")
 			return
 		}
 
+		serveData(w, r, rec, "c"+strconv.Itoa(chunk), buf.Bytes())
+		return
+	}
+
+	if live, ok := rh.liveChunkInfo(rec); ok && chunk > live.CurrentChunk {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("chunk not found"))
+		return
+	}
+
+	key := requestSessionKey(r, ps.ByName("region"), ps.ByName("id"))
+	info := lastChunkInfo(rec)
+	if chunk >= info.EndGameChunk && info.EndGameChunk > 0 {
+		rh.sessions.advance(key, sessionEnded)
+	} else if chunk > info.EndStartupChunk {
+		rh.sessions.advance(key, sessionPlaying)
+	}
+
+	_, err = rec.RetrieveChunkTo(chunk, buf)
+	if err != nil {
 		if err == recording.ErrMissingData {
 			w.Header().Set("Content-Type", "text/plain")
 			w.WriteHeader(http.StatusNotFound)
@@ -201,14 +253,13 @@ func (rh requestHandler) getGameDataChunk(w http.ResponseWriter, r *http.Request
 		w.Write([]byte("internal server error"))
 		return
 	}
+
+	serveData(w, r, rec, "c"+strconv.Itoa(chunk), buf.Bytes())
 }
 
-func (rh requestHandler) getKeyFrame(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	rec := rh.retriever(ps.ByName("region"), ps.ByName("id"))
-	if rec == nil {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("game not found"))
+func (rh *requestHandler) getKeyFrame(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rec, fetcher, ok := rh.retrieve(w, r, ps)
+	if !ok {
 		return
 	}
 
@@ -220,14 +271,35 @@ func (rh requestHandler) getKeyFrame(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
-	pipe := newHTTPWriterPipe(w, "application/octet-stream")
-	_, err = rec.RetrieveKeyFrameTo(frame, pipe)
-	if err != nil {
-		if pipe.HasWritten() {
-			log.Println("getKeyFrame silent error:", err)
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	if fetcher != nil {
+		if _, err := fetcher.KeyFrameTo(rec, frame, buf); err != nil {
+			writeProxyError(w, err, "keyframe not found")
 			return
 		}
 
+		serveData(w, r, rec, "k"+strconv.Itoa(frame), buf.Bytes())
+		return
+	}
+
+	if live, ok := rh.liveChunkInfo(rec); ok &&
+		frame > live.CurrentKeyFrame {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("keyframe not found"))
+		return
+	}
+
+	rh.sessions.advance(requestSessionKey(r, ps.ByName("region"),
+		ps.ByName("id")), sessionPlaying)
+
+	_, err = rec.RetrieveKeyFrameTo(frame, buf)
+	if err != nil {
 		if err == recording.ErrMissingData {
 			w.Header().Set("Content-Type", "text/plain")
 			w.WriteHeader(http.StatusNotFound)
@@ -242,16 +314,45 @@ func (rh requestHandler) getKeyFrame(w http.ResponseWriter, r *http.Request, ps
 		w.Write([]byte("internal server error"))
 		return
 	}
+
+	serveData(w, r, rec, "k"+strconv.Itoa(frame), buf.Bytes())
 }
 
-// Router returns a http.Handler that handles requests for recorded data.
+func (rh *requestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.router.ServeHTTP(w, stripPlaybackOptions(r))
+}
+
+// liveChunkInfo returns the delayed chunk info of a recording if it is
+// still in progress and the live relay is enabled.
+func (rh *requestHandler) liveChunkInfo(
+	rec *recording.Recording) (recording.ChunkInfo, bool) {
+	if rh.options.LiveDelay <= 0 || !isLive(rec) {
+		return recording.ChunkInfo{}, false
+	}
+
+	return delayedChunkInfo(rec, rh.options.LiveDelay), true
+}
+
+// Router returns a http.Handler that handles requests for recorded data,
+// both at PathHeader and with playback options in front of PathHeader.
+// It is equivalent to NewRouter with the default options.
 func Router(retriever Retriever) http.Handler {
-	handler := requestHandler{
-		retriever:        retriever,
-		newClientBuckets: make(map[client]leakybucket.Bucket),
+	return NewRouter(retriever, Options{})
+}
+
+// NewRouter returns a http.Handler that handles requests for recorded
+// data with the specified options.
+func NewRouter(retriever Retriever, options Options) http.Handler {
+	handler := &requestHandler{
+		retriever: retriever,
+		options:   options,
+		sessions:  newSessionStore(sessionTimeout, maxSessions),
+		versions:  newVersionCache(),
+		fetchers:  newFetcherStore(),
 	}
 
 	router := httprouter.New()
+	handler.router = router
 	router.GET(PathHeader+"/version", handler.version)
 	router.GET(PathHeader+"/getGameMetaData/:region/:id/*ignore",
 		handler.getGameMetadata)
@@ -261,6 +362,10 @@ func Router(retriever Retriever) http.Handler {
 		handler.getGameDataChunk)
 	router.GET(PathHeader+"/getKeyFrame/:region/:id/:frame/*ignore",
 		handler.getKeyFrame)
+	router.HEAD(PathHeader+"/getGameDataChunk/:region/:id/:chunk/*ignore",
+		handler.getGameDataChunk)
+	router.HEAD(PathHeader+"/getKeyFrame/:region/:id/:frame/*ignore",
+		handler.getKeyFrame)
 
-	return router
+	return handler
 }
@@ -0,0 +1,134 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/1lann/lol-replay/record"
+	"github.com/1lann/lol-replay/recording"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Errors that a Retriever can return to describe why a recording is not
+// available. They are mapped to HTTP status codes by the router. Any other
+// error is logged and reported as an internal server error.
+var (
+	// ErrNotFound is returned if there is no recording of the game. It is
+	// reported as 404 Not Found.
+	ErrNotFound = errors.New("replay: recording not found")
+	// ErrPending is returned if the recording of the game exists, but is
+	// not available yet, such as while it is being set up. It is reported
+	// as 503 Service Unavailable.
+	ErrPending = errors.New("replay: recording not available yet")
+	// ErrDeleted is returned if the recording of the game has been
+	// deleted. It is reported as 410 Gone.
+	ErrDeleted = errors.New("replay: recording deleted")
+	// ErrUnauthorized is returned if the client is not allowed to play the
+	// recording. It is reported as 403 Forbidden.
+	ErrUnauthorized = errors.New("replay: not authorized")
+)
+
+// pendingRetryAfter is the value of the Retry-After header sent with
+// ErrPending, in seconds.
+const pendingRetryAfter = "10"
+
+// A Retriever provides a recording for a given game ID and region. If the
+// recording is not available, one of ErrNotFound, ErrPending, ErrDeleted or
+// ErrUnauthorized should be returned to describe why. The context is the
+// context of the request for the recording, and can be given to
+// MarkPrivate for recordings whose responses must not be cached.
+type Retriever interface {
+	Retrieve(ctx context.Context, region, gameID string) (*recording.Recording,
+		error)
+}
+
+// RetrieverFunc is an adapter to allow the use of an ordinary function as a
+// Retriever.
+type RetrieverFunc func(ctx context.Context, region,
+	gameID string) (*recording.Recording, error)
+
+// Retrieve calls f(ctx, region, gameID).
+func (f RetrieverFunc) Retrieve(ctx context.Context, region,
+	gameID string) (*recording.Recording, error) {
+	return f(ctx, region, gameID)
+}
+
+// SimpleRetriever adapts a function which returns a nil recording if the
+// recording does not exist into a Retriever.
+func SimpleRetriever(f func(region, gameID string) *recording.Recording) Retriever {
+	return RetrieverFunc(func(_ context.Context, region,
+		gameID string) (*recording.Recording, error) {
+		rec := f(region, gameID)
+		if rec == nil {
+			return nil, ErrNotFound
+		}
+
+		return rec, nil
+	})
+}
+
+// writeRetrieveError writes the response for an error returned by a
+// Retriever.
+func writeRetrieveError(w http.ResponseWriter, err error) {
+	status := http.StatusNotFound
+	message := "game not found"
+
+	switch err {
+	case ErrNotFound:
+	case ErrPending:
+		w.Header().Set("Retry-After", pendingRetryAfter)
+		status = http.StatusServiceUnavailable
+		message = "recording not available yet"
+	case ErrDeleted:
+		status = http.StatusGone
+		message = "recording deleted"
+	case ErrUnauthorized:
+		status = http.StatusForbidden
+		message = "not authorized to watch this recording"
+	default:
+		log.Println("retrieve error:", err)
+		status = http.StatusInternalServerError
+		message = "internal server error"
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+// retrieve retrieves the recording of the game a request is for. If the
+// game should be proxied, the fetcher for the game is also returned. If the
+// recording is not available, the error is written to w and false is
+// returned. If the Retriever marks the recording as private, the response
+// is marked as not cacheable.
+func (rh *requestHandler) retrieve(w http.ResponseWriter, r *http.Request,
+	ps httprouter.Params) (*recording.Recording, *record.Fetcher, bool) {
+	ctx, ok := rh.requestContext(r, ps.ByName("region"), ps.ByName("id"))
+	if !ok {
+		writeRetrieveError(w, ErrUnauthorized)
+		return nil, nil, false
+	}
+
+	private := new(bool)
+	ctx = context.WithValue(ctx, privateContextKey, private)
+
+	rec, err := rh.retriever.Retrieve(ctx, ps.ByName("region"),
+		ps.ByName("id"))
+	if *private {
+		w.Header().Set("Cache-Control", privateCacheControl)
+	}
+
+	if err == nil && rec == nil {
+		err = ErrNotFound
+	}
+
+	if err != nil {
+		writeRetrieveError(w, err)
+		return nil, nil, false
+	}
+
+	fetcher, _ := rh.proxyFetcher(ps.ByName("region"), ps.ByName("id"), rec)
+	return rec, fetcher, true
+}
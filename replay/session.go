@@ -0,0 +1,185 @@
+package replay
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sessions are kept until they have not been used for sessionTimeout. At
+// most maxSessions are tracked at a time, after which the least recently
+// used sessions are forgotten.
+const (
+	sessionTimeout = time.Hour
+	maxSessions    = 10000
+)
+
+type sessionState int
+
+// A playback session starts in sessionStart when the client requests the
+// game's metadata, where the client is told that the game starts at the
+// first chunk so that it plays from the beginning. Once the client starts
+// downloading the game it is playing, and is told about all of the recorded
+// data. Once the client has downloaded the last chunk of the game the
+// session has ended. Requesting the metadata again restarts the session.
+const (
+	sessionStart sessionState = iota
+	sessionPlaying
+	sessionEnded
+)
+
+// sessionKey identifies a session for a game. Clients that are not part of
+// an explicit playback session are tracked by their IP address instead,
+// with an id of "ip:" followed by the IP address.
+type sessionKey struct {
+	id     string
+	region string
+	gameID string
+}
+
+type session struct {
+	key      sessionKey
+	state    sessionState
+	lastSeen time.Time
+}
+
+// sessionStore tracks the state of sessions. It is safe for concurrent use.
+// Sessions are kept in a list ordered by when they were last used, so that
+// expired sessions and the least recently used sessions can be removed
+// without scanning every session.
+type sessionStore struct {
+	sessions map[sessionKey]*list.Element
+	order    *list.List
+	mutex    *sync.Mutex
+	timeout  time.Duration
+	max      int
+}
+
+func newSessionStore(timeout time.Duration, max int) *sessionStore {
+	return &sessionStore{
+		sessions: make(map[sessionKey]*list.Element),
+		order:    list.New(),
+		mutex:    new(sync.Mutex),
+		timeout:  timeout,
+		max:      max,
+	}
+}
+
+// NewSessionID returns a new random session ID.
+func NewSessionID() string {
+	data := make([]byte, 8)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(data)
+}
+
+// SessionPath returns the path prefix for a session ID, which is appended
+// to the host given to spectator clients.
+func SessionPath(id string) string {
+	return SessionPathHeader + "/" + id
+}
+
+// requestSessionKey returns the key of the session a request for a game
+// belongs to. Requests which are not part of an explicit playback session
+// are identified by the IP address of the client.
+func requestSessionKey(r *http.Request, region, gameID string) sessionKey {
+	return sessionKey{requestClientID(r), region, gameID}
+}
+
+// requestClientID returns the ID of the playback session of a request, or
+// "ip:" followed by the IP address of the client if the request is not
+// part of an explicit playback session.
+func requestClientID(r *http.Request) string {
+	if id := requestOptions(r).session; id != "" {
+		return id
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return "ip:" + ip
+}
+
+// touch returns the session for key, creating it if it does not exist, and
+// marks it as the most recently used session. The store's mutex must be
+// locked before touch is called.
+func (s *sessionStore) touch(key sessionKey) *session {
+	now := time.Now()
+	s.prune(now)
+
+	if elem, found := s.sessions[key]; found {
+		sess := elem.Value.(*session)
+		sess.lastSeen = now
+		s.order.MoveToFront(elem)
+		return sess
+	}
+
+	for s.order.Len() >= s.max && s.order.Len() > 0 {
+		s.remove(s.order.Back())
+	}
+
+	sess := &session{
+		key:      key,
+		state:    sessionStart,
+		lastSeen: now,
+	}
+	s.sessions[key] = s.order.PushFront(sess)
+	return sess
+}
+
+// get returns the state of a session, creating the session if it does not
+// exist.
+func (s *sessionStore) get(key sessionKey) sessionState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.touch(key).state
+}
+
+// advance moves a session forward to the specified state. Sessions only
+// move backwards when they are restarted.
+func (s *sessionStore) advance(key sessionKey, state sessionState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess := s.touch(key)
+	if state > sess.state {
+		sess.state = state
+	}
+}
+
+// restart moves a session back to the start, which happens when the client
+// is relaunched with the same session.
+func (s *sessionStore) restart(key sessionKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.touch(key).state = sessionStart
+}
+
+// prune removes sessions that have not been used since the timeout. The
+// store's mutex must be locked before prune is called.
+func (s *sessionStore) prune(now time.Time) {
+	for elem := s.order.Back(); elem != nil; elem = s.order.Back() {
+		if now.Sub(elem.Value.(*session).lastSeen) <= s.timeout {
+			return
+		}
+
+		s.remove(elem)
+	}
+}
+
+// remove removes a session from the store. The store's mutex must be
+// locked before remove is called.
+func (s *sessionStore) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.sessions, elem.Value.(*session).key)
+}
@@ -0,0 +1,268 @@
+package replay
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+func testSessionKey(i int) sessionKey {
+	return sessionKey{"viewer" + strconv.Itoa(i), "NA1", "1"}
+}
+
+// checkSessionStore checks that the map and list of a session store agree
+// with each other, and that the store holds at most its maximum.
+func checkSessionStore(t *testing.T, s *sessionStore) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.sessions) != s.order.Len() {
+		t.Fatal("store has", len(s.sessions), "sessions but", s.order.Len(),
+			"in its order")
+	}
+
+	if len(s.sessions) > s.max {
+		t.Fatal("store has", len(s.sessions), "sessions, more than", s.max)
+	}
+
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		if s.sessions[elem.Value.(*session).key] != elem {
+			t.Fatal("session in order is not in map")
+		}
+	}
+}
+
+func TestSessionStates(t *testing.T) {
+	s := newSessionStore(time.Hour, 10)
+	key := testSessionKey(0)
+
+	if state := s.get(key); state != sessionStart {
+		t.Fatal("new session is in state", state)
+	}
+
+	s.advance(key, sessionPlaying)
+	if state := s.get(key); state != sessionPlaying {
+		t.Fatal("advanced session is in state", state)
+	}
+
+	s.advance(key, sessionEnded)
+	s.advance(key, sessionPlaying)
+	if state := s.get(key); state != sessionEnded {
+		t.Fatal("session moved backwards to state", state)
+	}
+
+	s.restart(key)
+	if state := s.get(key); state != sessionStart {
+		t.Fatal("restarted session is in state", state)
+	}
+
+	if state := s.get(testSessionKey(1)); state != sessionStart {
+		t.Fatal("other session is in state", state)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s := newSessionStore(50*time.Millisecond, 10)
+
+	s.advance(testSessionKey(0), sessionPlaying)
+	s.advance(testSessionKey(1), sessionPlaying)
+	time.Sleep(100 * time.Millisecond)
+	s.get(testSessionKey(2))
+
+	checkSessionStore(t, s)
+	if len(s.sessions) != 1 {
+		t.Fatal("expired sessions were not removed, store has",
+			len(s.sessions), "sessions")
+	}
+
+	if state := s.get(testSessionKey(0)); state != sessionStart {
+		t.Fatal("expired session is in state", state)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	s := newSessionStore(time.Hour, 100)
+
+	for i := 0; i < 100; i++ {
+		s.advance(testSessionKey(i), sessionPlaying)
+	}
+
+	// Session 0 is used again, so session 1 is the least recently used.
+	s.get(testSessionKey(0))
+
+	for i := 100; i < 150; i++ {
+		s.advance(testSessionKey(i), sessionPlaying)
+	}
+
+	checkSessionStore(t, s)
+	if len(s.sessions) != 100 {
+		t.Fatal("store has", len(s.sessions), "sessions")
+	}
+
+	if _, found := s.sessions[testSessionKey(0)]; !found {
+		t.Error("recently used session was removed")
+	}
+
+	for i := 1; i <= 50; i++ {
+		if _, found := s.sessions[testSessionKey(i)]; found {
+			t.Error("least recently used session", i, "was kept")
+		}
+	}
+
+	for i := 51; i < 150; i++ {
+		if _, found := s.sessions[testSessionKey(i)]; !found {
+			t.Error("session", i, "was removed")
+		}
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	const viewers = 5000
+	s := newSessionStore(time.Hour, 1000)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < viewers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			key := testSessionKey(i)
+			for j := 0; j < 10; j++ {
+				s.get(key)
+				s.advance(key, sessionPlaying)
+				s.advance(key, sessionEnded)
+				s.restart(key)
+				s.get(testSessionKey((i + j) % viewers))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	checkSessionStore(t, s)
+}
+
+func newSessionTestRecording(t *testing.T) *recording.Recording {
+	file, err := ioutil.TempFile("", "session-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		file.Close()
+		os.Remove(file.Name())
+	})
+
+	rec, err := recording.NewRecording(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec.StoreGameInfo(recording.GameInfo{
+		Platform: "NA1",
+		GameID:   "1",
+		Version:  "1.2.3",
+	})
+	rec.StoreGameMetadata(strings.NewReader(`{"gameKey":{"gameId":1}}`))
+
+	for i := 1; i <= 12; i++ {
+		rec.StoreChunk(i, strings.NewReader("chunk"))
+	}
+
+	for i := 1; i <= 5; i++ {
+		rec.StoreKeyFrame(i, strings.NewReader("key frame"))
+	}
+
+	info := recording.ChunkInfo{
+		CurrentChunk:    3,
+		NextChunk:       3,
+		StartGameChunk:  3,
+		EndStartupChunk: 2,
+		CurrentKeyFrame: 1,
+		EndGameChunk:    12,
+		Duration:        30000,
+	}
+	rec.StoreFirstChunkInfo(info)
+
+	info.CurrentChunk = 12
+	info.NextChunk = 12
+	info.CurrentKeyFrame = 5
+	rec.StoreLastChunkInfo(info)
+	rec.DeclareComplete()
+
+	return rec
+}
+
+// TestConcurrentViewers plays a recording with thousands of viewers at the
+// same time, each of which must be told to start from the beginning of the
+// game before it starts playing, and about the whole game afterwards.
+func TestConcurrentViewers(t *testing.T) {
+	const viewers = 2000
+	rec := newSessionTestRecording(t)
+	router := Router(SimpleRetriever(func(region,
+		gameID string) *recording.Recording {
+		if region == "NA1" && gameID == "1" {
+			return rec
+		}
+
+		return nil
+	}))
+
+	lastChunk := func(prefix string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", prefix+
+			"/observer-mode/rest/consumer/getLastChunkInfo/NA1/1/0/token",
+			nil))
+
+		var info recording.ChunkInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+			t.Error("invalid chunk info:", w.Body.String())
+		}
+
+		return info.CurrentChunk
+	}
+
+	get := func(prefix, path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", prefix+
+			"/observer-mode/rest/consumer/"+path, nil))
+		return w.Code
+	}
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < viewers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			prefix := SessionPath(NewSessionID())
+			if code := get(prefix,
+				"getGameMetaData/NA1/1/0/token"); code != 200 {
+				t.Error("metadata returned", code)
+				return
+			}
+
+			if chunk := lastChunk(prefix); chunk != 3 {
+				t.Error("new viewer told the last chunk is", chunk)
+			}
+
+			if code := get(prefix,
+				"getGameDataChunk/NA1/1/4/token"); code != 200 {
+				t.Error("chunk returned", code)
+			}
+
+			if chunk := lastChunk(prefix); chunk != 12 {
+				t.Error("playing viewer told the last chunk is", chunk)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
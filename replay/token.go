@@ -0,0 +1,95 @@
+package replay
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenPathHeader is the path prefix used to give a client a signed token
+// that allows it to play a game, followed by a token from SignToken. Tokens
+// are only checked if Options.TokenKey is set.
+const TokenPathHeader = "/token"
+
+// Token holds the claims of a valid token given to a client. It is made
+// available to the Retriever through TokenFromContext.
+type Token struct {
+	Region  string
+	GameID  string
+	Expires time.Time
+}
+
+// SignToken returns a token signed with key that allows a client to play a
+// game until expires.
+func SignToken(key []byte, region, gameID string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 36)
+	return expiry + "." + tokenSignature(key, region, gameID, expiry)
+}
+
+// TokenPath returns the path prefix for a token, which is appended to the
+// host given to spectator clients.
+func TokenPath(token string) string {
+	return TokenPathHeader + "/" + token
+}
+
+// TokenFromContext returns the claims of the valid token that was given
+// with a request, if any.
+func TokenFromContext(ctx context.Context) (Token, bool) {
+	token, ok := ctx.Value(tokenContextKey).(Token)
+	return token, ok
+}
+
+func tokenSignature(key []byte, region, gameID, expiry string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(region + "/" + gameID + "/" + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken returns the claims of a token if it is correctly signed with
+// key for the game and has not expired.
+func verifyToken(key []byte, token, region, gameID string) (Token, bool) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return Token{}, false
+	}
+
+	expected := tokenSignature(key, region, gameID, parts[0])
+	if !hmac.Equal([]byte(parts[1]), []byte(expected)) {
+		return Token{}, false
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 36, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return Token{}, false
+	}
+
+	return Token{
+		Region:  region,
+		GameID:  gameID,
+		Expires: time.Unix(expiry, 0),
+	}, true
+}
+
+// requestContext returns the context to give to the Retriever for a
+// request for a game. If the request has a valid token for the game, its
+// claims are added to the context. False is returned if the request has a
+// token that is not valid for the game.
+func (rh *requestHandler) requestContext(r *http.Request, region,
+	gameID string) (context.Context, bool) {
+	token := requestOptions(r).token
+	if token == "" || len(rh.options.TokenKey) == 0 {
+		return r.Context(), true
+	}
+
+	claims, ok := verifyToken(rh.options.TokenKey, token, region, gameID)
+	if !ok {
+		return r.Context(), false
+	}
+
+	return context.WithValue(r.Context(), tokenContextKey, claims), true
+}
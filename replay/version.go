@@ -0,0 +1,174 @@
+package replay
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/1lann/lol-replay/record"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Versions retrieved from a platform's spectator endpoint are cached for
+// versionCacheTime, and failures to retrieve them for versionRetryTime, so
+// that the spectator endpoint is not requested every time a client starts,
+// and a platform which is unreachable does not slow down every request.
+const (
+	versionCacheTime = time.Hour
+	versionRetryTime = time.Minute
+)
+
+type cachedVersion struct {
+	version string
+	expiry  time.Time
+}
+
+// versionCache remembers the versions of the games that clients have
+// played, and caches the versions of platforms. It is safe for concurrent
+// use.
+type versionCache struct {
+	clients   map[string]cachedVersion
+	platforms map[string]cachedVersion
+	latest    string
+	mutex     *sync.Mutex
+}
+
+func newVersionCache() *versionCache {
+	return &versionCache{
+		clients:   make(map[string]cachedVersion),
+		platforms: make(map[string]cachedVersion),
+		mutex:     new(sync.Mutex),
+	}
+}
+
+// remember stores the version of the game that a client is playing, which
+// is used to answer the client's version requests until the client plays
+// another game.
+func (c *versionCache) remember(client, version string) {
+	if version == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if len(c.clients) >= maxSessions {
+		for id, cached := range c.clients {
+			if now.After(cached.expiry) || len(c.clients) >= maxSessions {
+				delete(c.clients, id)
+			}
+		}
+	}
+
+	c.clients[client] = cachedVersion{
+		version: version,
+		expiry:  now.Add(sessionTimeout),
+	}
+	c.latest = version
+}
+
+// client returns the version of the game the client last played.
+func (c *versionCache) client(client string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, found := c.clients[client]
+	if !found || time.Now().After(cached.expiry) {
+		return "", false
+	}
+
+	return cached.version, true
+}
+
+// latestVersion returns the version of the game that was most recently
+// played by any client.
+func (c *versionCache) latestVersion() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.latest
+}
+
+// platform returns the current version of a platform from its spectator
+// endpoint, or from the cache if it was recently retrieved.
+func (c *versionCache) platform(platform string) (string, bool) {
+	c.mutex.Lock()
+	cached, found := c.platforms[platform]
+	c.mutex.Unlock()
+
+	if found && time.Now().Before(cached.expiry) {
+		return cached.version, cached.version != ""
+	}
+
+	version, err := record.GetPlatformVersion(platform)
+	if err != nil {
+		version = ""
+		cached = cachedVersion{expiry: time.Now().Add(versionRetryTime)}
+	} else {
+		cached = cachedVersion{version, time.Now().Add(versionCacheTime)}
+	}
+
+	c.mutex.Lock()
+	c.platforms[platform] = cached
+	c.mutex.Unlock()
+
+	return version, version != ""
+}
+
+// resolveVersion returns the version to report to a client. In order of
+// preference, it is the version of the game the client was given through
+// its playback options, the version of the game the client last played,
+// the version of the platform of the game the client was given, the
+// version of the game most recently played by any client, and lastly the
+// version of the first registered platform that responds.
+func (rh *requestHandler) resolveVersion(r *http.Request) string {
+	opts := requestOptions(r)
+	if opts.gameID != "" {
+		ctx, _ := rh.requestContext(r, opts.region, opts.gameID)
+		rec, err := rh.retriever.Retrieve(ctx, opts.region, opts.gameID)
+		if err == nil && rec != nil {
+			if version := rec.RetrieveGameInfo().Version; version != "" {
+				return version
+			}
+		}
+	}
+
+	if version, ok := rh.versions.client(requestClientID(r)); ok {
+		return version
+	}
+
+	if opts.region != "" {
+		if version, ok := rh.versions.platform(opts.region); ok {
+			return version
+		}
+	}
+
+	if version := rh.versions.latestVersion(); version != "" {
+		return version
+	}
+
+	for _, platform := range record.Platforms() {
+		if version, ok := rh.versions.platform(platform.ID); ok {
+			return version
+		}
+	}
+
+	return ""
+}
+
+func (rh *requestHandler) version(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Cache-Control", sessionCacheControl)
+
+	version := rh.resolveVersion(r)
+	if version == "" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("version unavailable"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(version))
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1lann/lol-replay/recording"
+	"github.com/1lann/lol-replay/replay"
+)
+
+// Recordings are public by default, which means they are listed on the web
+// interface and API, and can be played by anyone. Unlisted recordings are
+// only shown to people with a share link to the recording, and private
+// recordings are only shown to admins. If tokens are enabled, unlisted and private
+// recordings can only be played with a signed replay command. Private
+// recordings require tokens, as they cannot be played otherwise.
+const (
+	visibilityPublic   = "public"
+	visibilityUnlisted = "unlisted"
+	visibilityPrivate  = "private"
+)
+
+const (
+	visibilityFile       = "visibility.json"
+	adminCookie          = "glr_admin"
+	adminSessionLifetime = 7 * 24 * time.Hour
+	csrfHeader           = "X-CSRF-Token"
+	shareParam           = "share"
+	defaultTokenLifetime = 24
+)
+
+var (
+	errInvalidVisibility     = errors.New("invalid visibility")
+	errPrivateRequiresTokens = errors.New("private recordings require " +
+		"token_secret to be set")
+)
+
+var visibilities = make(map[string]string)
+var visibilitiesMutex = new(sync.RWMutex)
+
+// adminSession is the session of an admin who has logged in to the web
+// interface, which is identified by the value of its cookie. Sessions end
+// when they expire, or when the admin key is changed.
+type adminSession struct {
+	csrfToken string
+	adminKey  string
+	expires   time.Time
+}
+
+var adminSessions = make(map[string]adminSession)
+var adminSessionsMutex = new(sync.Mutex)
+
+func isValidVisibility(visibility string) bool {
+	return visibility == visibilityPublic ||
+		visibility == visibilityUnlisted || visibility == visibilityPrivate
+}
+
+// loadVisibilities reads the visibility of recordings from the recordings
+// directory.
+func loadVisibilities() {
+	data, err := ioutil.ReadFile(getConfig().RecordingsDirectory + "/" +
+		visibilityFile)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Println("failed to read visibilities:", err)
+		return
+	}
+
+	visibilitiesMutex.Lock()
+	defer visibilitiesMutex.Unlock()
+
+	if err := json.Unmarshal(data, &visibilities); err != nil {
+		log.Println("failed to read visibilities:", err)
+	}
+}
+
+// recordingVisibility returns the visibility of a recording by its key.
+func recordingVisibility(keyName string) string {
+	visibilitiesMutex.RLock()
+	defer visibilitiesMutex.RUnlock()
+
+	if visibility, found := visibilities[keyName]; found {
+		return visibility
+	}
+
+	if defaultVisibility := getConfig().DefaultVisibility; defaultVisibility != "" {
+		return defaultVisibility
+	}
+
+	return visibilityPublic
+}
+
+// setVisibility sets and saves the visibility of a recording by its key.
+func setVisibility(keyName, visibility string) error {
+	if !isValidVisibility(visibility) {
+		return errInvalidVisibility
+	}
+
+	if visibility == visibilityPrivate && !tokensEnabled() {
+		return errPrivateRequiresTokens
+	}
+
+	visibilitiesMutex.Lock()
+	defer visibilitiesMutex.Unlock()
+
+	visibilities[keyName] = visibility
+
+	data, err := json.Marshal(visibilities)
+	if err != nil {
+		return err
+	}
+
+	location := getConfig().RecordingsDirectory + "/" + visibilityFile
+	if err := ioutil.WriteFile(location+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(location+".tmp", location)
+}
+
+// isAdmin returns whether or not a request is authenticated as an admin,
+// either with the admin key as a bearer token in the Authorization header,
+// or with the session cookie set by loginAdmin. Requests other than GET and
+// HEAD which use the session cookie must also carry the session's CSRF
+// token in the X-CSRF-Token header, so that other sites cannot make changes
+// on behalf of a logged in admin.
+func isAdmin(r *http.Request) bool {
+	adminKey := getConfig().AdminKey
+	if adminKey == "" {
+		return false
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth,
+		"Bearer ") {
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth,
+			"Bearer ")), []byte(adminKey)) == 1
+	}
+
+	session, ok := lookupAdminSession(r)
+	if !ok {
+		return false
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)),
+		[]byte(session.csrfToken)) == 1
+}
+
+// lookupAdminSession returns the admin session of the cookie of a request.
+func lookupAdminSession(r *http.Request) (adminSession, bool) {
+	cookie, err := r.Cookie(adminCookie)
+	if err != nil {
+		return adminSession{}, false
+	}
+
+	adminKey := getConfig().AdminKey
+
+	adminSessionsMutex.Lock()
+	defer adminSessionsMutex.Unlock()
+
+	session, found := adminSessions[cookie.Value]
+	if !found {
+		return adminSession{}, false
+	}
+
+	if time.Now().After(session.expires) || adminKey == "" ||
+		subtle.ConstantTimeCompare([]byte(session.adminKey),
+			[]byte(adminKey)) != 1 {
+		delete(adminSessions, cookie.Value)
+		return adminSession{}, false
+	}
+
+	return session, true
+}
+
+// loginAdmin starts an admin session and sets its cookie if the request
+// carries the admin key as the key query parameter, and returns whether or
+// not it did. The cookie only holds a random session ID, and is only sent
+// over HTTPS to the server itself.
+func loginAdmin(w http.ResponseWriter, r *http.Request) bool {
+	adminKey := getConfig().AdminKey
+	if adminKey == "" || subtle.ConstantTimeCompare(
+		[]byte(r.URL.Query().Get("key")), []byte(adminKey)) != 1 {
+		return false
+	}
+
+	id := randomToken()
+	now := time.Now()
+
+	adminSessionsMutex.Lock()
+	for existing, session := range adminSessions {
+		if now.After(session.expires) {
+			delete(adminSessions, existing)
+		}
+	}
+
+	adminSessions[id] = adminSession{
+		csrfToken: randomToken(),
+		adminKey:  adminKey,
+		expires:   now.Add(adminSessionLifetime),
+	}
+	adminSessionsMutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(adminSessionLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return true
+}
+
+// csrfToken returns the CSRF token of the admin session of a request, or an
+// empty string if the request has no admin session.
+func csrfToken(r *http.Request) string {
+	session, ok := lookupAdminSession(r)
+	if !ok {
+		return ""
+	}
+
+	return session.csrfToken
+}
+
+func randomToken() string {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// canList returns whether or not a recording should be shown to the
+// client of a request. Unlisted recordings are shown when the request
+// carries the share code of the recording.
+func canList(r *http.Request, keyName string) bool {
+	switch recordingVisibility(keyName) {
+	case visibilityPublic:
+		return true
+	case visibilityUnlisted:
+		return isAdmin(r) || isShared(r, keyName)
+	default:
+		return isAdmin(r)
+	}
+}
+
+// shareCode returns the code which requests for an unlisted recording must
+// carry as the share query parameter. It is an HMAC of the recording's key,
+// so that it cannot be guessed from the key, signed with the token_secret,
+// or with the admin_key if tokens are disabled. An empty string is returned
+// if neither is set, in which case unlisted recordings cannot be shared.
+func shareCode(keyName string) string {
+	conf := getConfig()
+	secret := conf.TokenSecret
+	if secret == "" {
+		secret = conf.AdminKey
+	}
+
+	if secret == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("share:" + keyName))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// shareLink returns the link to a recording on the web interface, which
+// includes the recording's share code.
+func shareLink(keyName string) string {
+	link := "/?game=" + url.QueryEscape(keyName)
+	if code := shareCode(keyName); code != "" {
+		link += "&" + shareParam + "=" + code
+	}
+
+	return link
+}
+
+// isShared returns whether or not a request carries the share code of a
+// recording.
+func isShared(r *http.Request, keyName string) bool {
+	code := shareCode(keyName)
+	return code != "" && hmac.Equal([]byte(r.URL.Query().Get(shareParam)),
+		[]byte(code))
+}
+
+// tokensEnabled returns whether or not replay commands are signed.
+func tokensEnabled() bool {
+	return getConfig().TokenSecret != ""
+}
+
+// tokenPath returns the path prefix with a signed token for a recording, or
+// an empty string if tokens are disabled.
+func tokenPath(info recording.GameInfo) string {
+	conf := getConfig()
+	if conf.TokenSecret == "" {
+		return ""
+	}
+
+	lifetime := conf.TokenLifetimeHours
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+
+	return replay.TokenPath(replay.SignToken([]byte(conf.TokenSecret),
+		info.Platform, info.GameID,
+		time.Now().Add(time.Duration(lifetime)*time.Hour)))
+}
+
+// authorizePlayback returns an error if a recording may not be played with
+// the context of a request to the replay router. Recordings which are not
+// public are marked as private so that they are not cached. Private
+// recordings can never be played without a token, even if tokens have
+// since been disabled.
+func authorizePlayback(ctx context.Context, keyName string) error {
+	visibility := recordingVisibility(keyName)
+	if visibility == visibilityPublic {
+		return nil
+	}
+
+	replay.MarkPrivate(ctx)
+
+	if visibility == visibilityUnlisted && !tokensEnabled() {
+		return nil
+	}
+
+	if _, ok := replay.TokenFromContext(ctx); !ok {
+		return replay.ErrUnauthorized
+	}
+
+	return nil
+}
+
+// serveVisibility handles requests by admins to change the visibility of a
+// recording, with the recording's key and new visibility given as the game
+// and visibility form values.
+func serveVisibility(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+
+	if !isAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"unauthorized"}`))
+		return
+	}
+
+	keyName := r.FormValue("game")
+
+	recordingsMutex.RLock()
+	_, found := recordings[keyName]
+	recordingsMutex.RUnlock()
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"recording not found"}`))
+		return
+	}
+
+	if err := setVisibility(keyName, r.FormValue("visibility")); err != nil {
+		if err == errInvalidVisibility {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid visibility"}`))
+			return
+		} else if err == errPrivateRequiresTokens {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"private recordings require ` +
+				`token_secret to be set"}`))
+			return
+		}
+
+		log.Println("failed to save visibility:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"internal server error"}`))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"ok":true}`))
+}
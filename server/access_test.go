@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+// useTestConfig replaces the configuration and the visibility of
+// recordings until the end of a test.
+func useTestConfig(t *testing.T, conf *configuration,
+	testVisibilities map[string]string) {
+	oldConfig := getConfig()
+	setConfig(conf)
+
+	visibilitiesMutex.Lock()
+	oldVisibilities := visibilities
+	visibilities = testVisibilities
+	visibilitiesMutex.Unlock()
+
+	t.Cleanup(func() {
+		setConfig(oldConfig)
+
+		visibilitiesMutex.Lock()
+		visibilities = oldVisibilities
+		visibilitiesMutex.Unlock()
+	})
+}
+
+func TestShareCode(t *testing.T) {
+	useTestConfig(t, &configuration{TokenSecret: "secret",
+		AdminKey: "admin"}, map[string]string{
+		"NA1_1": visibilityUnlisted,
+		"NA1_2": visibilityPrivate,
+	})
+
+	get := func(target string) *http.Request {
+		return httptest.NewRequest("GET", target, nil)
+	}
+
+	code := shareCode("NA1_1")
+	if code == "" || code == shareCode("NA1_2") {
+		t.Fatal("share codes are not unique:", code)
+	}
+
+	if !canList(get("/"), "NA1_3") {
+		t.Error("public recording is not listed")
+	}
+
+	if canList(get("/"), "NA1_1") || canList(get("/?game=NA1_1"), "NA1_1") {
+		t.Error("unlisted recording is listed without its share code")
+	}
+
+	if canList(get("/?share="+shareCode("NA1_3")), "NA1_1") {
+		t.Error("unlisted recording is listed with another share code")
+	}
+
+	if !canList(get(shareLink("NA1_1")), "NA1_1") {
+		t.Error("unlisted recording is not listed with its share link")
+	}
+
+	if canList(get(shareLink("NA1_2")), "NA1_2") {
+		t.Error("private recording is listed with its share link")
+	}
+
+	admin := get("/")
+	admin.Header.Set("Authorization", "Bearer admin")
+	if !canList(admin, "NA1_1") || !canList(admin, "NA1_2") {
+		t.Error("recordings are not listed to admins")
+	}
+
+	if script := launchScriptURL(recording.GameInfo{Platform: "NA1",
+		GameID: "1"}, ".bat"); script != "/launch/NA1_1.bat?share="+code {
+		t.Error("unexpected launch script path:", script)
+	}
+
+	if script := launchScriptURL(recording.GameInfo{Platform: "NA1",
+		GameID: "3"}, ".bat"); script != "/launch/NA1_3.bat" {
+		t.Error("unexpected launch script path:", script)
+	}
+
+	// Share codes are signed with the admin key if tokens are disabled.
+	setConfig(&configuration{AdminKey: "admin"})
+	if adminCode := shareCode("NA1_1"); adminCode == "" || adminCode == code {
+		t.Error("unexpected share code with tokens disabled:", adminCode)
+	}
+
+	setConfig(&configuration{})
+	if shareCode("NA1_1") != "" || canList(get("/?share="), "NA1_1") {
+		t.Error("recording is shared without a secret")
+	}
+
+	if link := shareLink("NA1_1"); strings.Contains(link, "share") {
+		t.Error("unexpected share link without a secret:", link)
+	}
+}
+
+func TestAdminSession(t *testing.T) {
+	useTestConfig(t, &configuration{AdminKey: "admin"},
+		make(map[string]string))
+
+	w := httptest.NewRecorder()
+	if loginAdmin(w, httptest.NewRequest("GET", "/?key=wrong", nil)) ||
+		len(w.Result().Cookies()) != 0 {
+		t.Fatal("logged in with the wrong key")
+	}
+
+	w = httptest.NewRecorder()
+	serveView(w, httptest.NewRequest("GET", "/2?key=admin&queue=420", nil))
+	if w.Code != http.StatusSeeOther ||
+		w.Header().Get("Location") != "/2?queue=420" {
+		t.Fatal("login was not redirected without the key:", w.Code,
+			w.Header().Get("Location"))
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatal("login set", len(cookies), "cookies")
+	}
+
+	cookie := cookies[0]
+	if cookie.Name != adminCookie || cookie.Value == "" ||
+		strings.Contains(cookie.Value, "admin") || !cookie.HttpOnly ||
+		!cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("unexpected admin cookie: %+v", cookie)
+	}
+
+	request := func(method, target string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		r.AddCookie(cookie)
+		return r
+	}
+
+	if !isAdmin(request("GET", "/")) {
+		t.Error("GET request with session cookie is not an admin")
+	}
+
+	if isAdmin(httptest.NewRequest("GET", "/?key=admin", nil)) {
+		t.Error("key query parameter is accepted outside of logging in")
+	}
+
+	// Changes with the session cookie require the CSRF token.
+	token := csrfToken(request("GET", "/"))
+	if token == "" {
+		t.Fatal("session has no CSRF token")
+	}
+
+	for _, method := range []string{"POST", "PATCH", "DELETE"} {
+		if isAdmin(request(method, "/api/v1/record")) {
+			t.Error(method, "request without CSRF token is an admin")
+		}
+
+		r := request(method, "/api/v1/record")
+		r.Header.Set(csrfHeader, "wrong")
+		if isAdmin(r) {
+			t.Error(method, "request with wrong CSRF token is an admin")
+		}
+
+		r.Header.Set(csrfHeader, token)
+		if !isAdmin(r) {
+			t.Error(method, "request with CSRF token is not an admin")
+		}
+
+		r = httptest.NewRequest(method, "/api/v1/record", nil)
+		r.Header.Set("Authorization", "Bearer admin")
+		if !isAdmin(r) {
+			t.Error(method, "request with bearer token is not an admin")
+		}
+	}
+
+	// Sessions end when the admin key changes.
+	setConfig(&configuration{AdminKey: "changed"})
+	if isAdmin(request("GET", "/")) {
+		t.Error("session is still valid after the admin key changed")
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -14,6 +12,7 @@ type apiPlayer struct {
 	ProfileIconID int    `json:"profile_icon_id"`
 	SummonerName  string `json:"summoner_name"`
 	SummonerID    string `json:"summoner_id"`
+	PUUID         string `json:"puuid"`
 	ChampionName  string `json:"champion_name"`
 	ChampionID    int    `json:"champion_id"`
 }
@@ -24,6 +23,9 @@ type apiRecording struct {
 	LastWriteTime time.Time   `json:"last_write_time"`
 	IsRecording   bool        `json:"is_recording"`
 	ReplayString  string      `json:"replay_string"`
+	LaunchLink    string      `json:"launch_link"`
+	WindowsScript string      `json:"windows_script"`
+	MacScript     string      `json:"mac_script"`
 	Players       []apiPlayer `json:"players"`
 	Queue         string      `json:"queue"`
 }
@@ -47,22 +49,28 @@ func writeLastGames(skip int, games int, r *http.Request, w io.Writer) {
 		defer recordingsMutex.RUnlock()
 
 		for i := len(sortedRecordings) - 1; i >= len(sortedRecordings)-n; i-- {
-			rec := sortedRecordings[i].rec
+			entry := sortedRecordings[i].current()
+			info := entry.Info
+			if !canList(r, info.Platform+"_"+info.GameID) {
+				continue
+			}
 
 			var game gameInfoMetadata
-			rec.RetrieveUserMetadata(&game)
-			info := rec.RetrieveGameInfo()
+			if entry.Game != nil {
+				game = *entry.Game
+			}
 
-			replayCode := "replay " + strings.Split(r.Host, ":")[0] + ":" +
-				strconv.Itoa(config.ShowReplayPortAs) + " " + info.EncryptionKey +
-				" " + info.GameID + " " + info.Platform
+			replayCode := replayString(r, info, "")
 
 			thisRecording := apiRecording{
 				Region:        info.Platform,
 				RecordTime:    info.RecordTime,
-				LastWriteTime: rec.LastWriteTime(),
+				LastWriteTime: entry.LastWriteTime,
 				IsRecording:   sortedRecordings[i].recording,
 				ReplayString:  replayCode,
+				LaunchLink:    launchLink(r, info),
+				WindowsScript: launchScriptURL(info, ".bat"),
+				MacScript:     launchScriptURL(info, ".command"),
 				Queue:         getQueue(game.GameQueueConfigID),
 			}
 
@@ -76,6 +84,7 @@ func writeLastGames(skip int, games int, r *http.Request, w io.Writer) {
 					ProfileIconID: player.ProfileIconID,
 					SummonerName:  player.SummonerName,
 					SummonerID:    player.SummonerID,
+					PUUID:         player.PUUID,
 					ChampionName:  championName,
 					ChampionID:    player.ChampionID,
 				})
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1lann/lol-replay/record"
+)
+
+// apiV1Path is the path prefix of version 1 of the REST API. Recordings
+// are listed at apiV1Path + "recordings", and individual recordings are
+// at apiV1Path + "recordings/<platform>/<game ID>". The recordings which the
+// retention policy would remove are reported at apiV1Path + "retention".
+// Recording files are uploaded to apiV1Path + "recordings", and downloaded
+// from apiV1Path + "recordings/<platform>/<game ID>/file". Games are
+// recorded on request by posting to apiV1Path + "record".
+const (
+	apiV1Path         = "/api/v1/"
+	defaultAPIV1Limit = 20
+	maxAPIV1Limit     = 100
+)
+
+// The statuses of recordings reported by the API. statusPending is only
+// reported for games requested through apiV1Path + "record" which have not
+// received any data yet, and such recordings are not listed.
+const (
+	statusPending    = "pending"
+	statusRecording  = "recording"
+	statusComplete   = "complete"
+	statusIncomplete = "incomplete"
+)
+
+type apiV1Error struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+type apiV1Participant struct {
+	SummonerName  string `json:"summoner_name"`
+	SummonerID    string `json:"summoner_id"`
+	PUUID         string `json:"puuid"`
+	RiotID        string `json:"riot_id"`
+	TeamID        int    `json:"team_id"`
+	ChampionID    int    `json:"champion_id"`
+	ChampionName  string `json:"champion_name"`
+	ProfileIconID int    `json:"profile_icon_id"`
+	Spell1ID      int    `json:"spell1_id"`
+	Spell2ID      int    `json:"spell2_id"`
+	Bot           bool   `json:"bot"`
+}
+
+type apiV1Ban struct {
+	ChampionID   int    `json:"champion_id"`
+	ChampionName string `json:"champion_name"`
+	TeamID       int    `json:"team_id"`
+	PickTurn     int    `json:"pick_turn"`
+}
+
+type apiV1Gaps struct {
+	Chunks    []int `json:"chunks"`
+	KeyFrames []int `json:"key_frames"`
+}
+
+type apiV1Recording struct {
+	Key             string             `json:"key"`
+	Platform        string             `json:"platform"`
+	GameID          string             `json:"game_id"`
+	Region          string             `json:"region"`
+	Version         string             `json:"version"`
+	Status          string             `json:"status"`
+	Visibility      string             `json:"visibility"`
+	Pinned          bool               `json:"pinned"`
+	ShareLink       string             `json:"share_link,omitempty"`
+	RecordTime      time.Time          `json:"record_time"`
+	LastWriteTime   time.Time          `json:"last_write_time"`
+	DurationSeconds int                `json:"duration_seconds"`
+	SizeBytes       int64              `json:"size_bytes"`
+	Queue           string             `json:"queue"`
+	QueueID         int                `json:"queue_id"`
+	MapID           int                `json:"map_id"`
+	GameMode        string             `json:"game_mode"`
+	Participants    []apiV1Participant `json:"participants"`
+	Bans            []apiV1Ban         `json:"bans"`
+	Gaps            apiV1Gaps          `json:"gaps"`
+	ReplayString    string             `json:"replay_string"`
+	LaunchLink      string             `json:"launch_link"`
+	WindowsScript   string             `json:"windows_script"`
+	MacScript       string             `json:"mac_script"`
+}
+
+type apiV1List struct {
+	Recordings []apiV1Recording `json:"recordings"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// apiV1Patch is the body of a request to edit a recording. Only the
+// visibility and pin of a recording can be edited, as the details of the
+// game are stored in the recording itself. Fields which are not given are
+// left unchanged.
+type apiV1Patch struct {
+	Visibility *string `json:"visibility"`
+	Pinned     *bool   `json:"pinned"`
+}
+
+type apiV1Removal struct {
+	Key        string    `json:"key"`
+	Reason     string    `json:"reason"`
+	RecordTime time.Time `json:"record_time"`
+	SizeBytes  int64     `json:"size_bytes"`
+}
+
+// apiV1Retention is the dry run of the next cleanup by the retention
+// policy.
+type apiV1Retention struct {
+	Recordings []apiV1Removal `json:"recordings"`
+	TotalBytes int64          `json:"total_bytes"`
+}
+
+// writeAPIV1Error writes an error response in the format used by every
+// endpoint of the API.
+func writeAPIV1Error(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiV1Error{Status: status, Error: message})
+}
+
+func writeAPIV1(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("error responding to API request:", err)
+	}
+}
+
+// serveAPIV1 handles requests to version 1 of the REST API.
+func serveAPIV1(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods",
+		"GET, POST, PATCH, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers",
+		"Authorization, Content-Type")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path,
+		apiV1Path), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "openapi.json":
+		if r.Method != http.MethodGet {
+			writeAPIV1Error(w, http.StatusMethodNotAllowed,
+				"method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(openAPIDocument))
+	case len(parts) == 1 && parts[0] == "recordings":
+		switch r.Method {
+		case http.MethodGet:
+			serveAPIV1List(w, r)
+		case http.MethodPost:
+			serveAPIV1Upload(w, r)
+		default:
+			writeAPIV1Error(w, http.StatusMethodNotAllowed,
+				"method not allowed")
+		}
+	case len(parts) == 1 && parts[0] == "record":
+		if r.Method != http.MethodPost {
+			writeAPIV1Error(w, http.StatusMethodNotAllowed,
+				"method not allowed")
+			return
+		}
+
+		serveAPIV1Record(w, r)
+	case len(parts) == 1 && parts[0] == "retention":
+		if r.Method != http.MethodGet {
+			writeAPIV1Error(w, http.StatusMethodNotAllowed,
+				"method not allowed")
+			return
+		}
+
+		serveAPIV1Retention(w, r)
+	case len(parts) == 4 && parts[0] == "recordings" && parts[3] == "file":
+		if !record.IsValidPlatform(parts[1]) || !isNumber(parts[2]) {
+			writeAPIV1Error(w, http.StatusNotFound, "recording not found")
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			writeAPIV1Error(w, http.StatusMethodNotAllowed,
+				"method not allowed")
+			return
+		}
+
+		serveAPIV1Download(w, r, parts[1]+"_"+parts[2])
+	case len(parts) == 3 && parts[0] == "recordings":
+		keyName := parts[1] + "_" + parts[2]
+		if !record.IsValidPlatform(parts[1]) || !isNumber(parts[2]) {
+			writeAPIV1Error(w, http.StatusNotFound, "recording not found")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			serveAPIV1Recording(w, r, keyName)
+		case http.MethodPatch:
+			serveAPIV1Patch(w, r, keyName)
+		case http.MethodDelete:
+			serveAPIV1Delete(w, r, keyName)
+		default:
+			writeAPIV1Error(w, http.StatusMethodNotAllowed,
+				"method not allowed")
+		}
+	default:
+		writeAPIV1Error(w, http.StatusNotFound, "not found")
+	}
+}
+
+// encodeCursor returns the cursor which continues a listing after a
+// recording.
+func encodeCursor(recordTime time.Time, keyName string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(
+		strconv.FormatInt(recordTime.UnixNano(), 36) + "." + keyName))
+}
+
+func decodeCursor(cursor string) (time.Time, string, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	parts := strings.SplitN(string(data), ".", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", false
+	}
+
+	nano, err := strconv.ParseInt(parts[0], 36, 64)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	return time.Unix(0, nano), parts[1], true
+}
+
+// serveAPIV1List lists recordings from newest to oldest, filtered by the
+// query parameters read by parseRecordingFilter. The cursor query parameter
+// continues a previous listing from its next_cursor, which stays valid when
+// recordings are added or deleted.
+func serveAPIV1List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := defaultAPIV1Limit
+	if query.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 || limit > maxAPIV1Limit {
+			writeAPIV1Error(w, http.StatusBadRequest, "limit must be "+
+				"between 1 and "+strconv.Itoa(maxAPIV1Limit))
+			return
+		}
+	}
+
+	filter, err := parseRecordingFilter(query)
+	if err != nil {
+		writeAPIV1Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var afterTime time.Time
+	var afterKey string
+	if cursor := query.Get("cursor"); cursor != "" {
+		var ok bool
+		afterTime, afterKey, ok = decodeCursor(cursor)
+		if !ok {
+			writeAPIV1Error(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+
+	recordingsMutex.RLock()
+	defer recordingsMutex.RUnlock()
+
+	type listed struct {
+		key        string
+		recordTime time.Time
+		internal   *internalRecording
+	}
+
+	var all []listed
+	for _, internalRec := range sortedRecordings {
+		if !filter.matches(internalRec) {
+			continue
+		}
+
+		info := internalRec.current().Info
+		all = append(all, listed{info.Platform + "_" + info.GameID,
+			info.RecordTime, internalRec})
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].recordTime.Equal(all[j].recordTime) {
+			return all[i].key > all[j].key
+		}
+		return all[i].recordTime.After(all[j].recordTime)
+	})
+
+	list := apiV1List{Recordings: []apiV1Recording{}}
+	for _, item := range all {
+		if afterKey != "" && (item.recordTime.After(afterTime) ||
+			(item.recordTime.Equal(afterTime) && item.key >= afterKey)) {
+			continue
+		}
+
+		if !canList(r, item.key) {
+			continue
+		}
+
+		if len(list.Recordings) == limit {
+			last := list.Recordings[limit-1]
+			list.NextCursor = encodeCursor(last.RecordTime, last.Key)
+			break
+		}
+
+		list.Recordings = append(list.Recordings,
+			newAPIV1Recording(r, item.key, item.internal))
+	}
+
+	writeAPIV1(w, http.StatusOK, list)
+}
+
+func serveAPIV1Recording(w http.ResponseWriter, r *http.Request,
+	keyName string) {
+	recordingsMutex.RLock()
+	defer recordingsMutex.RUnlock()
+
+	internalRec, found := recordings[keyName]
+	if !found || !canList(r, keyName) {
+		writeAPIV1Error(w, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	if internalRec.location == "" {
+		writeAPIV1(w, http.StatusOK, newPendingAPIV1Recording(keyName))
+		return
+	}
+
+	writeAPIV1(w, http.StatusOK, newAPIV1Recording(r, keyName, internalRec))
+}
+
+// requireAdmin writes an error response and returns false if the request
+// is not authenticated as an admin.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if isAdmin(r) {
+		return true
+	}
+
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeAPIV1Error(w, http.StatusUnauthorized, "unauthorized")
+	return false
+}
+
+func serveAPIV1Patch(w http.ResponseWriter, r *http.Request,
+	keyName string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var patch apiV1Patch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patch); err != nil {
+		writeAPIV1Error(w, http.StatusBadRequest, "invalid body: "+
+			err.Error())
+		return
+	}
+
+	recordingsMutex.RLock()
+	_, found := recordings[keyName]
+	recordingsMutex.RUnlock()
+
+	if !found {
+		writeAPIV1Error(w, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	if patch.Visibility != nil {
+		if err := setVisibility(keyName, *patch.Visibility); err != nil {
+			if err == errInvalidVisibility {
+				writeAPIV1Error(w, http.StatusBadRequest,
+					"visibility must be one of public, unlisted or private")
+				return
+			} else if err == errPrivateRequiresTokens {
+				writeAPIV1Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			log.Println("failed to save visibility:", err)
+			writeAPIV1Error(w, http.StatusInternalServerError,
+				"internal server error")
+			return
+		}
+	}
+
+	if patch.Pinned != nil {
+		if err := setPinned(keyName, *patch.Pinned); err != nil {
+			log.Println("failed to save pinned recordings:", err)
+			writeAPIV1Error(w, http.StatusInternalServerError,
+				"internal server error")
+			return
+		}
+	}
+
+	serveAPIV1Recording(w, r, keyName)
+}
+
+// serveAPIV1Retention reports the recordings which the next cleanup by the
+// retention policy would remove, without removing them.
+func serveAPIV1Retention(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	recordingsMutex.RLock()
+	plan := retentionPlan(getConfig(), time.Now())
+	recordingsMutex.RUnlock()
+
+	report := apiV1Retention{Recordings: []apiV1Removal{}}
+	for _, removal := range plan {
+		report.Recordings = append(report.Recordings, apiV1Removal{
+			Key:        removal.key,
+			Reason:     removal.reason,
+			RecordTime: removal.entry.Info.RecordTime,
+			SizeBytes:  removal.entry.FileSize,
+		})
+		report.TotalBytes += removal.entry.FileSize
+	}
+
+	writeAPIV1(w, http.StatusOK, report)
+}
+
+func serveAPIV1Delete(w http.ResponseWriter, r *http.Request,
+	keyName string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	recordingsMutex.Lock()
+	defer recordingsMutex.Unlock()
+
+	internalRec, found := recordings[keyName]
+	if !found {
+		writeAPIV1Error(w, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	if internalRec.temporary || internalRec.recording {
+		writeAPIV1Error(w, http.StatusConflict,
+			"recording is in progress")
+		return
+	}
+
+	removeRecording(internalRec)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordingStatus returns the status of a recording to report through the
+// API. Recordings which are about to be resumed are reported as recording,
+// as only recordings without any data are pending.
+func recordingStatus(internalRec *internalRecording) string {
+	switch {
+	case internalRec.temporary || internalRec.recording:
+		return statusRecording
+	case internalRec.entry.Complete:
+		return statusComplete
+	default:
+		return statusIncomplete
+	}
+}
+
+// newPendingAPIV1Recording describes a recording which has not started yet
+// for the API.
+func newPendingAPIV1Recording(keyName string) apiV1Recording {
+	parts := strings.SplitN(keyName, "_", 2)
+	return apiV1Recording{
+		Key:          keyName,
+		Platform:     parts[0],
+		GameID:       parts[len(parts)-1],
+		Region:       platformRegion(parts[0]),
+		Status:       statusPending,
+		Visibility:   recordingVisibility(keyName),
+		Pinned:       isPinned(keyName),
+		Participants: []apiV1Participant{},
+		Bans:         []apiV1Ban{},
+		Gaps:         apiV1Gaps{Chunks: []int{}, KeyFrames: []int{}},
+	}
+}
+
+// newAPIV1Recording describes a recording for the API. recordingsMutex must
+// be RLocked before newAPIV1Recording is called.
+func newAPIV1Recording(r *http.Request, keyName string,
+	internalRec *internalRecording) apiV1Recording {
+	entry := internalRec.current()
+	info := entry.Info
+
+	result := apiV1Recording{
+		Key:             keyName,
+		Platform:        info.Platform,
+		GameID:          info.GameID,
+		Region:          platformRegion(info.Platform),
+		Version:         info.Version,
+		Status:          recordingStatus(internalRec),
+		Visibility:      recordingVisibility(keyName),
+		Pinned:          isPinned(keyName),
+		RecordTime:      info.RecordTime,
+		LastWriteTime:   entry.LastWriteTime,
+		DurationSeconds: int(entry.Duration.Seconds()),
+		SizeBytes:       entry.FileSize,
+		Participants:    []apiV1Participant{},
+		Bans:            []apiV1Ban{},
+		ReplayString:    replayString(r, info, ""),
+		LaunchLink:      launchLink(r, info),
+		WindowsScript:   launchScriptURL(info, ".bat"),
+		MacScript:       launchScriptURL(info, ".command"),
+	}
+
+	if result.Visibility == visibilityUnlisted {
+		result.ShareLink = shareLink(keyName)
+	}
+
+	result.Gaps.Chunks = entry.MissingChunks
+	result.Gaps.KeyFrames = entry.MissingKeyFrames
+	if result.Gaps.Chunks == nil {
+		result.Gaps.Chunks = []int{}
+	}
+	if result.Gaps.KeyFrames == nil {
+		result.Gaps.KeyFrames = []int{}
+	}
+
+	if entry.Game == nil {
+		return result
+	}
+
+	game := *entry.Game
+
+	result.Queue = getQueue(game.GameQueueConfigID)
+	result.QueueID = game.GameQueueConfigID
+	result.MapID = game.MapID
+	result.GameMode = game.GameMode
+
+	championsMutex.RLock()
+	defer championsMutex.RUnlock()
+
+	for _, player := range game.Participants {
+		result.Participants = append(result.Participants, apiV1Participant{
+			SummonerName:  player.SummonerName,
+			SummonerID:    player.SummonerID,
+			PUUID:         player.PUUID,
+			RiotID:        player.RiotID,
+			TeamID:        player.TeamID,
+			ChampionID:    player.ChampionID,
+			ChampionName:  allChampions[player.ChampionID].Name,
+			ProfileIconID: player.ProfileIconID,
+			Spell1ID:      player.Spell1Id,
+			Spell2ID:      player.Spell2Id,
+			Bot:           player.Bot,
+		})
+	}
+
+	for _, ban := range game.BannedChampions {
+		result.Bans = append(result.Bans, apiV1Ban{
+			ChampionID:   ban.ChampionID,
+			ChampionName: allChampions[ban.ChampionID].Name,
+			TeamID:       ban.TeamID,
+			PickTurn:     ban.PickTurn,
+		})
+	}
+
+	return result
+}
@@ -155,6 +155,15 @@ span.record:before {
 	color: #ffffff;
 }
 
+.start-codes {
+	margin-top: 10px;
+	font-size: 12px;
+}
+
+.start-codes > a {
+	margin-left: 5px;
+}
+
 .fa-external-link {
 	margin-left: 5px;
 }`),
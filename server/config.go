@@ -2,49 +2,226 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
 	"os"
+	"sync"
 
 	"github.com/1lann/lol-replay/record"
 )
 
 type configPlayer struct {
 	ID       string `json:"id"`
+	RiotID   string `json:"riot_id"`
 	Platform string `json:"platform"`
 }
 
+type configFeatured struct {
+	Platforms   []string `json:"platforms"`
+	Queues      []int    `json:"queues"`
+	Maps        []int    `json:"maps"`
+	Champions   []int    `json:"champions"`
+	MinTier     string   `json:"min_tier"`
+	RefreshRate int      `json:"refresh_rate_seconds"`
+}
+
+// configRetentionRule limits the recordings of a player, a queue, or a
+// player in a queue. A recording is subject to the first rule it matches.
+type configRetentionRule struct {
+	Player     string `json:"player"`
+	Queue      *int   `json:"queue"`
+	MaxCount   int    `json:"max_count"`
+	MaxAgeDays int    `json:"max_age_days"`
+}
+
+type configRetention struct {
+	MaxTotalBytes int64                 `json:"max_total_bytes"`
+	MaxAgeDays    int                   `json:"max_age_days"`
+	Rules         []configRetentionRule `json:"rules"`
+}
+
 type configuration struct {
-	Players             []configPlayer `json:"players"`
-	RecordingsDirectory string         `json:"recordings_directory"`
-	BindAddress         string         `json:"bind_address"`
-	RiotAPIKey          string         `json:"riot_api_key"`
-	RefreshRate         int            `json:"refresh_rate_seconds"`
-	KeepNumRecordings   int            `json:"keep_num_recordings"`
-	ShowPerPage         int            `json:"show_per_page"`
-	ShowReplayPortAs    int            `json:"show_replay_port_as"`
+	Platforms           []record.Platform `json:"platforms"`
+	Players             []configPlayer    `json:"players"`
+	Featured            configFeatured    `json:"featured"`
+	RecordingsDirectory string            `json:"recordings_directory"`
+	BindAddress         string            `json:"bind_address"`
+	RiotAPIKey          string            `json:"riot_api_key"`
+	RefreshRate         int               `json:"refresh_rate_seconds"`
+	SpectatorVersion    int               `json:"spectator_version"`
+	KeepNumRecordings   int               `json:"keep_num_recordings"`
+	Retention           configRetention   `json:"retention"`
+	ShowPerPage         int               `json:"show_per_page"`
+	ShowReplayPortAs    int               `json:"show_replay_port_as"`
+	LiveDelaySeconds    int               `json:"live_delay_seconds"`
+	Proxy               bool              `json:"proxy"`
+	AdminKey            string            `json:"admin_key"`
+	TokenSecret         string            `json:"token_secret"`
+	TokenLifetimeHours  int               `json:"token_lifetime_hours"`
+	DefaultVisibility   string            `json:"default_visibility"`
+	WindowsGameDir      string            `json:"windows_game_directory"`
+	MacGameDir          string            `json:"mac_game_directory"`
+
+	// platforms are the platforms to register when the configuration is
+	// used, which are the default platforms with Platforms applied.
+	platforms []record.Platform
 }
 
-var config configuration
+var (
+	config      *configuration
+	configMutex = new(sync.RWMutex)
+)
 
-func readConfiguration(location string) {
+// getConfig returns the current configuration. The configuration is
+// replaced as a whole when it is reloaded, so it must not be modified, and
+// the same configuration should be used for the duration of an operation.
+func getConfig() *configuration {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return config
+}
+
+// setConfig replaces the current configuration.
+func setConfig(newConfig *configuration) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	config = newConfig
+}
+
+// readConfiguration reads and validates the configuration at location.
+// The platforms of the configuration are not registered until
+// usePlatforms is called, so that an invalid configuration does not modify
+// the registered platforms.
+func readConfiguration(location string) (*configuration, error) {
+	conf, err := decodeConfiguration(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// decodeConfiguration reads the configuration at location without
+// validating it.
+func decodeConfiguration(location string) (*configuration, error) {
 	file, err := os.Open(location)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	defer file.Close()
 
-	dec := json.NewDecoder(file)
+	conf := new(configuration)
+	if err := json.NewDecoder(file).Decode(conf); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
 
-	err = dec.Decode(&config)
+// validate checks the configuration, and resolves the platforms to
+// register when it is used.
+func (conf *configuration) validate() error {
+	var err error
+	conf.platforms, err = record.ResolvePlatforms(conf.Platforms)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	for _, player := range conf.Players {
+		if player.ID == "" && player.RiotID == "" {
+			return errors.New("players must have an id or riot_id")
+		}
+
+		if player.RiotID != "" {
+			if _, _, ok := splitRiotID(player.RiotID); !ok {
+				return errors.New("riot_id " + player.RiotID +
+					" is not of the form Name#TAG")
+			}
+		}
+
+		if !conf.hasPlatform(player.Platform) {
+			return errors.New(player.name() + "'s platform " +
+				player.Platform + " is not a valid platform")
+		}
 	}
 
-	for _, player := range config.Players {
-		if !record.IsValidPlatform(player.Platform) {
-			log.Fatal(player.ID + "'s platform " + player.Platform +
-				" is not a valid platform.")
+	for _, platform := range conf.Featured.Platforms {
+		if !conf.hasPlatform(platform) {
+			return errors.New("featured games platform " + platform +
+				" is not a valid platform")
 		}
 	}
+
+	if conf.Featured.MinTier != "" && tierRank(conf.Featured.MinTier) < 0 {
+		return errors.New("featured games min_tier " +
+			conf.Featured.MinTier + " is not a valid tier")
+	}
+
+	if conf.DefaultVisibility != "" &&
+		!isValidVisibility(conf.DefaultVisibility) {
+		return errors.New("default_visibility " +
+			conf.DefaultVisibility +
+			" is not one of public, unlisted or private")
+	}
+
+	if conf.DefaultVisibility == visibilityPrivate && conf.TokenSecret == "" {
+		return errors.New("default_visibility private requires " +
+			"token_secret to be set")
+	}
+
+	if conf.SpectatorVersion != 0 &&
+		!isValidSpectatorVersion(conf.SpectatorVersion) {
+		return errors.New("spectator_version must be 4 or 5")
+	}
+
+	if conf.KeepNumRecordings <= 0 {
+		return errors.New("keep_num_recordings must be positive")
+	}
+
+	if conf.Retention.MaxTotalBytes < 0 || conf.Retention.MaxAgeDays < 0 {
+		return errors.New("retention max_total_bytes and " +
+			"max_age_days must not be negative")
+	}
+
+	for _, rule := range conf.Retention.Rules {
+		if rule.Player == "" && rule.Queue == nil {
+			return errors.New("retention rules must have a player " +
+				"or queue")
+		}
+
+		if rule.MaxCount < 0 || rule.MaxAgeDays < 0 {
+			return errors.New("retention rule max_count and " +
+				"max_age_days must not be negative")
+		}
+	}
+
+	if conf.ShowPerPage <= 0 {
+		return errors.New("show_per_page must be positive")
+	}
+
+	return nil
+}
+
+// hasPlatform returns whether or not a platform is one of the platforms of
+// the configuration.
+func (conf *configuration) hasPlatform(id string) bool {
+	for _, platform := range conf.platforms {
+		if platform.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// usePlatforms replaces the registered platforms with the platforms of the
+// configuration.
+func (conf *configuration) usePlatforms() error {
+	return record.SetPlatforms(conf.platforms)
 }
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultFeaturedRefreshRate = 300
+
+var allTiers = []string{
+	"IRON",
+	"BRONZE",
+	"SILVER",
+	"GOLD",
+	"PLATINUM",
+	"EMERALD",
+	"DIAMOND",
+	"MASTER",
+	"GRANDMASTER",
+	"CHALLENGER",
+}
+
+type leagueEntry struct {
+	QueueType string `json:"queueType"`
+	Tier      string `json:"tier"`
+}
+
+// tierRank returns the position of a tier in allTiers, or -1 if the tier
+// is not valid.
+func tierRank(tier string) int {
+	for i, t := range allTiers {
+		if strings.EqualFold(t, tier) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// monitorFeatured monitors the featured games of the platforms in the
+// configuration. Like monitorPlayers, the configuration is read at the
+// start of every pass through the platforms.
+func monitorFeatured() {
+	log.Println("Monitoring featured games...")
+
+	// Games which have already been checked against the filters, so that
+	// the rank of the players in a game are only looked up once.
+	checked := make(map[string]bool)
+
+	for {
+		conf := getConfig()
+		if len(conf.Featured.Platforms) == 0 {
+			time.Sleep(idleMonitorWait)
+			continue
+		}
+
+		refreshRate := conf.Featured.RefreshRate
+		if refreshRate <= 0 {
+			refreshRate = defaultFeaturedRefreshRate
+		}
+
+		waitSeconds := float64(refreshRate) /
+			float64(len(conf.Featured.Platforms))
+		waitPeriod := time.Millisecond * time.Duration(waitSeconds*1000.0)
+
+		for _, platform := range conf.Featured.Platforms {
+			time.Sleep(waitPeriod)
+
+			games, err := pollFeatured(conf, platform, checked)
+			if err != nil {
+				log.Println("featured games on "+platform+":", err)
+				continue
+			}
+
+			for _, game := range games {
+				if startRecording(game) {
+					log.Println("recording featured game " +
+						game.PlatformID + "_" +
+						strconv.FormatInt(game.GameID, 10))
+				}
+			}
+		}
+
+		// Featured games only last as long as a game, so forget about
+		// games that have been checked once the list grows too large.
+		if len(checked) > 1000 {
+			checked = make(map[string]bool)
+		}
+	}
+}
+
+// pollFeatured retrieves the featured games of a platform, and returns the
+// games which pass the filters of conf. Games in checked are skipped, and
+// the games that are retrieved are added to checked.
+func pollFeatured(conf *configuration, platform string,
+	checked map[string]bool) ([]gameInfoMetadata, error) {
+	games, err := newSpectatorClient(platform,
+		conf.RiotAPIKey).featuredGames()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []gameInfoMetadata
+	for _, game := range games {
+		if game.PlatformID == "" {
+			game.PlatformID = platform
+		}
+
+		keyName := game.PlatformID + "_" + strconv.FormatInt(game.GameID, 10)
+		if checked[keyName] {
+			continue
+		}
+
+		checked[keyName] = true
+
+		if conf.Featured.matches(game) {
+			matched = append(matched, game)
+		}
+	}
+
+	return matched, nil
+}
+
+// matches returns whether or not a featured game passes the configured
+// filters. Empty filters match every game.
+func (f configFeatured) matches(game gameInfoMetadata) bool {
+	if len(f.Queues) > 0 && !containsInt(f.Queues, game.GameQueueConfigID) {
+		return false
+	}
+
+	if len(f.Maps) > 0 && !containsInt(f.Maps, game.MapID) {
+		return false
+	}
+
+	if len(f.Champions) > 0 {
+		found := false
+		for _, participant := range game.Participants {
+			if containsInt(f.Champions, participant.ChampionID) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if f.MinTier != "" {
+		return f.meetsMinTier(game)
+	}
+
+	return true
+}
+
+// meetsMinTier returns whether or not any of the participants of the game
+// have a ranked tier of at least the configured minimum tier. Participants
+// are identified by summoner ID or PUUID depending on the response, so if
+// none of the participants' ranks can be looked up, the game is considered
+// a match.
+func (f configFeatured) meetsMinTier(game gameInfoMetadata) bool {
+	minRank := tierRank(f.MinTier)
+	available := false
+
+	for _, participant := range game.Participants {
+		if participant.Bot {
+			continue
+		}
+
+		var method, path string
+		if participant.PUUID != "" {
+			method = "league-v4/by-puuid"
+			path = "/lol/league/v4/entries/by-puuid/" + participant.PUUID
+		} else if participant.SummonerID != "" {
+			method = "league-v4/by-summoner"
+			path = "/lol/league/v4/entries/by-summoner/" +
+				participant.SummonerID
+		} else {
+			continue
+		}
+
+		tier, err := summonerTier(method, riotAPIURL(game.PlatformID, path),
+			getConfig().RiotAPIKey)
+		if err != nil {
+			log.Println("featured games: failed to get rank of "+
+				participant.SummonerName+":", err)
+			continue
+		}
+
+		available = true
+		if tierRank(tier) >= minRank {
+			return true
+		}
+	}
+
+	return !available
+}
+
+// summonerTier retrieves the highest ranked tier of a summoner from the
+// league entries at url, which is a request to method of the Riot API. An
+// empty string is returned if the summoner is unranked.
+func summonerTier(method, url, apiKey string) (string, error) {
+	var entries []leagueEntry
+	if err := getRiotAPI(method, url+"?api_key="+apiKey,
+		&entries); err != nil {
+		return "", err
+	}
+
+	highest := ""
+	for _, entry := range entries {
+		if tierRank(entry.Tier) > tierRank(highest) {
+			highest = entry.Tier
+		}
+	}
+
+	return highest, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// newFakeFeaturedAPI starts a fake of the featured games and league APIs
+// of the Riot API, and uses its API key until the end of the test.
+func newFakeFeaturedAPI(t *testing.T) *fakeRiotAPI {
+	leagues := map[string]string{
+		"/lol/league/v4/entries/by-puuid/puuid-gold": `[{"queueType":` +
+			`"RANKED_SOLO_5x5","tier":"GOLD"}]`,
+		"/lol/league/v4/entries/by-puuid/puuid-master": `[{"queueType":` +
+			`"RANKED_FLEX_SR","tier":"SILVER"},{"queueType":` +
+			`"RANKED_SOLO_5x5","tier":"MASTER"}]`,
+		"/lol/league/v4/entries/by-puuid/puuid-unranked": `[]`,
+		"/lol/league/v4/entries/by-summoner/summoner-gold": `[{"queueType":` +
+			`"RANKED_SOLO_5x5","tier":"GOLD"}]`,
+	}
+
+	api := newFakeRiotAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path == "/lol/spectator/v5/featured-games" {
+			w.Write([]byte(`{"gameList":[` +
+				`{"gameId":1001,"mapId":11,"gameQueueConfigId":420,` +
+				`"participants":[{"puuid":"puuid-gold","championId":103},` +
+				`{"championId":1,"bot":true}],"platformId":""},` +
+				`{"gameId":1002,"mapId":11,"gameQueueConfigId":420,` +
+				`"participants":[{"puuid":"puuid-unranked","championId":51},` +
+				`{"puuid":"puuid-master","championId":238}],` +
+				`"platformId":"TEST1"},` +
+				`{"gameId":1003,"mapId":12,"gameQueueConfigId":450,` +
+				`"participants":[{"puuid":"puuid-master","championId":51}],` +
+				`"platformId":"TEST1"}]}`))
+			return
+		}
+
+		entries, found := leagues[r.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write([]byte(entries))
+	})
+
+	oldConfig := getConfig()
+	setConfig(&configuration{RiotAPIKey: "key", SpectatorVersion: 5})
+	t.Cleanup(func() {
+		setConfig(oldConfig)
+	})
+
+	return api
+}
+
+func featuredTestGame(participants ...gameParticipant) gameInfoMetadata {
+	return gameInfoMetadata{
+		GameID:            1,
+		GameQueueConfigID: 420,
+		MapID:             11,
+		PlatformID:        "TEST1",
+		Participants:      participants,
+	}
+}
+
+func TestFeaturedMatches(t *testing.T) {
+	game := featuredTestGame(gameParticipant{ChampionID: 103},
+		gameParticipant{ChampionID: 51})
+
+	tests := []struct {
+		filter  configFeatured
+		matches bool
+	}{
+		{configFeatured{}, true},
+		{configFeatured{Queues: []int{440, 420}}, true},
+		{configFeatured{Queues: []int{450}}, false},
+		{configFeatured{Maps: []int{11}}, true},
+		{configFeatured{Maps: []int{12}}, false},
+		{configFeatured{Champions: []int{1, 51}}, true},
+		{configFeatured{Champions: []int{1}}, false},
+		{configFeatured{Queues: []int{420}, Maps: []int{12}}, false},
+		{configFeatured{Queues: []int{420}, Maps: []int{11},
+			Champions: []int{103}}, true},
+	}
+
+	for _, test := range tests {
+		if test.filter.matches(game) != test.matches {
+			t.Errorf("expected filter %+v to match %v", test.filter,
+				test.matches)
+		}
+	}
+}
+
+func TestFeaturedMinTier(t *testing.T) {
+	api := newFakeFeaturedAPI(t)
+
+	gold := gameParticipant{PUUID: "puuid-gold"}
+	master := gameParticipant{PUUID: "puuid-master"}
+	unranked := gameParticipant{PUUID: "puuid-unranked"}
+	missing := gameParticipant{PUUID: "puuid-missing"}
+	v4Gold := gameParticipant{SummonerID: "summoner-gold"}
+	bot := gameParticipant{Bot: true, PUUID: "puuid-master"}
+
+	tests := []struct {
+		minTier string
+		game    gameInfoMetadata
+		matches bool
+	}{
+		{"GOLD", featuredTestGame(gold), true},
+		{"gold", featuredTestGame(v4Gold), true},
+		{"PLATINUM", featuredTestGame(gold), false},
+		{"PLATINUM", featuredTestGame(gold, master), true},
+		{"IRON", featuredTestGame(unranked), false},
+		{"PLATINUM", featuredTestGame(v4Gold, unranked), false},
+		// Bots are not looked up.
+		{"PLATINUM", featuredTestGame(gold, bot), false},
+		// Games where no ranks could be looked up are matched.
+		{"CHALLENGER", featuredTestGame(missing), true},
+		{"CHALLENGER", featuredTestGame(missing, gold), false},
+		{"CHALLENGER", featuredTestGame(bot), true},
+	}
+
+	for _, test := range tests {
+		filter := configFeatured{MinTier: test.minTier}
+		if filter.matches(test.game) != test.matches {
+			t.Errorf("expected %+v with minimum tier %s to match %v",
+				test.game.Participants, test.minTier, test.matches)
+		}
+	}
+
+	if n := api.count("/lol/league/v4/entries/by-puuid/puuid-master"); n != 1 {
+		t.Error("bot's rank was looked up, master looked up", n, "times")
+	}
+}
+
+func TestPollFeatured(t *testing.T) {
+	api := newFakeFeaturedAPI(t)
+
+	conf := &configuration{
+		RiotAPIKey: "key",
+		Featured: configFeatured{
+			Platforms: []string{"TEST1"},
+			Queues:    []int{420},
+			MinTier:   "DIAMOND",
+		},
+	}
+
+	checked := make(map[string]bool)
+	games, err := pollFeatured(conf, "TEST1", checked)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(games) != 1 || games[0].GameID != 1002 ||
+		games[0].PlatformID != "TEST1" {
+		t.Fatalf("unexpected featured games: %+v", games)
+	}
+
+	// Games without a platform are assumed to be on the polled platform.
+	for _, keyName := range []string{"TEST1_1001", "TEST1_1002",
+		"TEST1_1003"} {
+		if !checked[keyName] {
+			t.Error(keyName, "was not checked")
+		}
+	}
+
+	// Games which have been checked are not filtered again.
+	games, err = pollFeatured(conf, "TEST1", checked)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(games) != 0 {
+		t.Errorf("checked games were returned again: %+v", games)
+	}
+
+	if n := api.count("/lol/spectator/v5/featured-games"); n != 2 {
+		t.Error("featured games were retrieved", n, "times")
+	}
+
+	if n := api.count("/lol/league/v4/entries/by-puuid/puuid-gold"); n != 1 {
+		t.Error("rank was looked up", n, "times")
+	}
+
+	conf.RiotAPIKey = "wrong"
+	if _, err := pollFeatured(conf, "TEST1", checked); err == nil {
+		t.Error("expected error from featured games with wrong API key")
+	}
+}
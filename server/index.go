@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1lann/lol-replay/record"
+	"github.com/1lann/lol-replay/recording"
+	"github.com/1lann/lol-replay/replay"
+)
+
+// The index stores a summary of every recording in indexFile in the
+// recordings directory, so that recordings do not need to be opened to be
+// listed. Recordings are only opened when they are played or recorded, and
+// are closed again after they have not been used for recordingIdleTimeout.
+const (
+	indexFile            = "index.json"
+	recordingIdleTimeout = time.Minute * 10
+)
+
+var (
+	indexLocation string
+	indexEntries  = make(map[string]indexEntry)
+	indexMutex    = new(sync.Mutex)
+)
+
+// indexEntry is the summary of a recording stored in the index.
+type indexEntry struct {
+	Filename         string             `json:"filename"`
+	FileSize         int64              `json:"file_size"`
+	ModTime          time.Time          `json:"mod_time"`
+	Info             recording.GameInfo `json:"info"`
+	LastWriteTime    time.Time          `json:"last_write_time"`
+	Complete         bool               `json:"complete"`
+	Duration         time.Duration      `json:"duration"`
+	Game             *gameInfoMetadata  `json:"game"`
+	MissingChunks    []int              `json:"missing_chunks"`
+	MissingKeyFrames []int              `json:"missing_key_frames"`
+}
+
+// newIndexEntry returns the index entry of a recording stored in file.
+func newIndexEntry(rec *recording.Recording, file *os.File) indexEntry {
+	entry := indexEntry{
+		Filename:      path.Base(file.Name()),
+		Info:          rec.RetrieveGameInfo(),
+		LastWriteTime: rec.LastWriteTime(),
+		Complete:      rec.IsComplete(),
+		Duration:      recordingDuration(rec),
+	}
+
+	if stat, err := file.Stat(); err == nil {
+		entry.FileSize = stat.Size()
+		entry.ModTime = stat.ModTime()
+	}
+
+	if rec.HasUserMetadata() {
+		game := new(gameInfoMetadata)
+		if err := rec.RetrieveUserMetadata(game); err == nil {
+			entry.Game = game
+		}
+	}
+
+	entry.MissingChunks, entry.MissingKeyFrames = record.MissingData(rec)
+	return entry
+}
+
+// openIndex reads the index at location, which is saved to location when
+// it changes. A missing index is empty, and an index which cannot be read
+// is rebuilt from the recordings by loadRecordings.
+func openIndex(location string) error {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	indexLocation = location
+	indexEntries = make(map[string]indexEntry)
+
+	data, err := ioutil.ReadFile(location)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	entries := make(map[string]indexEntry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	indexEntries = entries
+	return nil
+}
+
+// updateIndex applies update to the entries of the index, and saves the
+// index.
+func updateIndex(update func(entries map[string]indexEntry)) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	update(indexEntries)
+
+	data, err := json.Marshal(indexEntries)
+	if err != nil {
+		log.Println("failed to encode index:", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(indexLocation+".tmp", data, 0644); err != nil {
+		log.Println("failed to save index:", err)
+		return
+	}
+
+	if err := os.Rename(indexLocation+".tmp", indexLocation); err != nil {
+		log.Println("failed to save index:", err)
+	}
+}
+
+// putIndexEntry stores the entry of a recording in the index.
+func putIndexEntry(keyName string, entry indexEntry) {
+	updateIndex(func(entries map[string]indexEntry) {
+		entries[keyName] = entry
+	})
+}
+
+// deleteIndexEntry removes the entry of a recording from the index.
+func deleteIndexEntry(keyName string) {
+	updateIndex(func(entries map[string]indexEntry) {
+		delete(entries, keyName)
+	})
+}
+
+// loadRecordings loads the recordings in the recordings directory. Entries
+// in the index are used for recordings whose files have not changed since
+// they were indexed, and other recordings are opened to be indexed.
+func loadRecordings(dir []os.FileInfo, dirName string) {
+	byFilename := make(map[string]string)
+	entries := make(map[string]indexEntry)
+
+	indexMutex.Lock()
+	for key, entry := range indexEntries {
+		byFilename[entry.Filename] = key
+		entries[key] = entry
+	}
+	indexMutex.Unlock()
+
+	// The index is saved once after every recording has been loaded.
+	changed := make(map[string]indexEntry)
+
+	for _, fileInfo := range dir {
+		if fileInfo.IsDir() {
+			continue
+		}
+
+		filename := path.Base(fileInfo.Name())
+
+		if path.Ext(filename) != ".glr" {
+			continue
+		}
+
+		key, found := byFilename[filename]
+		if found {
+			delete(byFilename, filename)
+
+			entry := entries[key]
+			if entry.FileSize == fileInfo.Size() &&
+				entry.ModTime.Equal(fileInfo.ModTime()) {
+				addRecording(key, dirName+"/"+filename, entry)
+				continue
+			}
+		}
+
+		key, entry, ok := indexRecording(dirName, filename)
+		if !ok {
+			continue
+		}
+
+		changed[key] = entry
+		addRecording(key, dirName+"/"+filename, entry)
+	}
+
+	// Store the new entries, and remove the entries of recordings which no
+	// longer exist.
+	if len(changed) > 0 || len(byFilename) > 0 {
+		updateIndex(func(entries map[string]indexEntry) {
+			for _, key := range byFilename {
+				delete(entries, key)
+			}
+
+			for key, entry := range changed {
+				entries[key] = entry
+			}
+		})
+	}
+
+	sort.Sort(byTime(sortedRecordings))
+}
+
+// indexRecording opens a recording file to create its index entry. Empty
+// recordings are deleted.
+func indexRecording(dirName, filename string) (string, indexEntry, bool) {
+	file, err := os.OpenFile(dirName+"/"+filename, os.O_RDWR, 0666)
+	if err != nil {
+		log.Println("failed to open "+filename+":", err)
+		return "", indexEntry{}, false
+	}
+
+	defer file.Close()
+
+	rec, err := recording.NewRecording(file)
+	if err != nil {
+		log.Println("failed to read recording "+filename+":", err)
+		return "", indexEntry{}, false
+	}
+
+	if !rec.HasGameMetadata() {
+		file.Close()
+		log.Println("deleting empty recording: " + filename)
+		if err := os.Remove(dirName + "/" + filename); err != nil {
+			log.Println("failed to delete empty recording:", err)
+		}
+		return "", indexEntry{}, false
+	}
+
+	info := rec.RetrieveGameInfo()
+	return info.Platform + "_" + info.GameID, newIndexEntry(rec, file), true
+}
+
+// addRecording adds a closed recording from its index entry.
+func addRecording(keyName, location string, entry indexEntry) {
+	internalRec := &internalRecording{
+		location: location,
+		entry:    entry,
+	}
+
+	if entry.Game != nil {
+		internalRec.summary = newRecordingSummary(*entry.Game)
+	} else {
+		internalRec.summary = newRecordingSummary(gameInfoMetadata{})
+	}
+
+	recordings[keyName] = internalRec
+	sortedRecordings = append(sortedRecordings, internalRec)
+}
+
+// openRecording returns the open recording of internalRec, opening its file
+// if it is closed. The file is opened without holding recordingsMutex, so
+// that opening a recording does not block requests for other recordings.
+func openRecording(internalRec *internalRecording) (*recording.Recording,
+	error) {
+	atomic.StoreInt64(&internalRec.lastUsed, time.Now().UnixNano())
+
+	recordingsMutex.RLock()
+	rec := internalRec.rec
+	location := internalRec.location
+	recordingsMutex.RUnlock()
+
+	if rec != nil {
+		return rec, nil
+	}
+
+	file, err := os.OpenFile(location, os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err = recording.NewRecording(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	recordingsMutex.Lock()
+	defer recordingsMutex.Unlock()
+
+	// The recording may have been opened or removed while its file was
+	// being opened.
+	if internalRec.rec != nil {
+		file.Close()
+		return internalRec.rec, nil
+	}
+
+	if internalRec.removed {
+		file.Close()
+		return nil, replay.ErrDeleted
+	}
+
+	internalRec.file = file
+	internalRec.rec = rec
+	return rec, nil
+}
+
+// closeRecording closes the file of a recording which is open, and updates
+// its index entry. recordingsMutex must be Locked before closeRecording is
+// called.
+func closeRecording(keyName string, internalRec *internalRecording) {
+	if internalRec.rec == nil {
+		return
+	}
+
+	entry := newIndexEntry(internalRec.rec, internalRec.file)
+
+	internalRec.rec.Lock()
+	internalRec.file.Close()
+	internalRec.rec.Unlock()
+
+	internalRec.entry = entry
+	internalRec.rec = nil
+	internalRec.file = nil
+
+	putIndexEntry(keyName, entry)
+}
+
+// closeIdleRecordings periodically closes recordings which are not being
+// recorded and have not been played for recordingIdleTimeout.
+func closeIdleRecordings() {
+	for {
+		time.Sleep(recordingIdleTimeout / 2)
+
+		recordingsMutex.Lock()
+		for keyName, internalRec := range recordings {
+			lastUsed := time.Unix(0, atomic.LoadInt64(&internalRec.lastUsed))
+			if internalRec.rec == nil || internalRec.temporary ||
+				internalRec.recording ||
+				time.Since(lastUsed) < recordingIdleTimeout {
+				continue
+			}
+
+			closeRecording(keyName, internalRec)
+		}
+		recordingsMutex.Unlock()
+	}
+}
+
+// current returns the index entry of a recording, which is up to date even
+// if the recording is being recorded. recordingsMutex must be RLocked
+// before current is called.
+func (internalRec *internalRecording) current() indexEntry {
+	if internalRec.rec == nil || !internalRec.recording {
+		return internalRec.entry
+	}
+
+	rec := internalRec.rec
+	entry := internalRec.entry
+	entry.Info = rec.RetrieveGameInfo()
+	entry.LastWriteTime = rec.LastWriteTime()
+	entry.Complete = rec.IsComplete()
+	entry.Duration = recordingDuration(rec)
+	entry.MissingChunks, entry.MissingKeyFrames = record.MissingData(rec)
+
+	if stat, err := internalRec.file.Stat(); err == nil {
+		entry.FileSize = stat.Size()
+	}
+
+	return entry
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/1lann/lol-replay/recording"
+)
+
+// launchPath is the path prefix of launch script downloads, which are
+// followed by the recording's key and the script's extension, such as
+// /launch/NA1_2345678901.bat.
+const launchPath = "/launch/"
+
+// The default locations of the League of Legends game directory, which
+// can be changed in the configuration.
+const (
+	defaultWindowsGameDir = `C:\Riot Games\League of Legends\Game`
+	defaultMacGameDir     = "/Applications/League of Legends.app/Contents/LoL/Game"
+)
+
+// launchArg is the data used to render the launch scripts.
+type launchArg struct {
+	GameDir  string
+	Host     string
+	Key      string
+	GameID   string
+	Platform string
+}
+
+var windowsLaunchTemplate = template.Must(template.New("bat").Parse(
+	strings.Replace(`@echo off
+rem Watch {{.Platform}} game {{.GameID}} with League of Legends.
+set "GAME_DIR={{.GameDir}}"
+if not exist "%GAME_DIR%\League of Legends.exe" (
+	echo League of Legends could not be found in %GAME_DIR%
+	pause
+	exit /b 1
+)
+cd /d "%GAME_DIR%"
+start "" "League of Legends.exe" "spectator {{.Host}} {{.Key}} {{.GameID}} {{.Platform}}" "-UseRads" "-Locale=en_US" "-GameBaseDir=.."
+`, "\n", "\r\n", -1)))
+
+var macLaunchTemplate = template.Must(template.New("command").Parse(
+	`#!/bin/sh
+# Watch {{.Platform}} game {{.GameID}} with League of Legends.
+GAME_DIR="{{.GameDir}}"
+if [ ! -d "$GAME_DIR" ]; then
+	echo "League of Legends could not be found in $GAME_DIR"
+	exit 1
+fi
+cd "$GAME_DIR" || exit 1
+riot_launched=true "./LeagueofLegends.app/Contents/MacOS/LeagueofLegends" "spectator {{.Host}} {{.Key}} {{.GameID}} {{.Platform}}" "-UseRads" "-Locale=en_US" "-GameBaseDir=.."
+`))
+
+// isSafeHost returns whether or not a host only contains characters that
+// are safe to put into a launch script.
+func isSafeHost(host string) bool {
+	for _, c := range host {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') &&
+			(c < '0' || c > '9') && !strings.ContainsRune(".-:/[]_", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSafeKey returns whether or not an encryption key only contains base64
+// characters, which are safe to put into a launch script. Encryption keys
+// can come from uploaded recordings and requests to record games, so they
+// are not always from Riot.
+func isSafeKey(key string) bool {
+	for _, c := range key {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') &&
+			(c < '0' || c > '9') && !strings.ContainsRune("+/=", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// launchLink returns a lolreplay:// link for a recording, which can be
+// opened by a registered launcher to start watching the recording.
+func launchLink(r *http.Request, info recording.GameInfo) string {
+	query := url.Values{}
+	query.Set("host", replayHost(r, info, ""))
+	query.Set("key", info.EncryptionKey)
+	query.Set("game", info.GameID)
+	query.Set("platform", info.Platform)
+
+	return "lolreplay://spectate?" + query.Encode()
+}
+
+// launchScriptURL returns the path to download a launch script for a
+// recording, where ext is .bat for Windows or .command for macOS. Paths to
+// unlisted recordings include the recording's share code, so that they can
+// be downloaded by anyone the recording is shared with.
+func launchScriptURL(info recording.GameInfo, ext string) string {
+	keyName := info.Platform + "_" + info.GameID
+	if recordingVisibility(keyName) != visibilityUnlisted {
+		return launchPath + keyName + ext
+	}
+
+	return launchPath + keyName + ext + "?" + shareParam + "=" +
+		url.QueryEscape(shareCode(keyName))
+}
+
+// serveLaunch serves the launch script downloads for recordings.
+func serveLaunch(w http.ResponseWriter, r *http.Request) {
+	filename := path.Base(r.URL.Path)
+	ext := path.Ext(filename)
+	keyName := strings.TrimSuffix(filename, ext)
+
+	conf := getConfig()
+	tmpl := windowsLaunchTemplate
+	gameDir := conf.WindowsGameDir
+	if gameDir == "" {
+		gameDir = defaultWindowsGameDir
+	}
+
+	if ext == ".command" {
+		tmpl = macLaunchTemplate
+		gameDir = conf.MacGameDir
+		if gameDir == "" {
+			gameDir = defaultMacGameDir
+		}
+	} else if ext != ".bat" {
+		http.NotFound(w, r)
+		return
+	}
+
+	recordingsMutex.RLock()
+	internalRec, found := recordings[keyName]
+	var info recording.GameInfo
+	if found && !internalRec.temporary {
+		info = internalRec.current().Info
+	}
+	recordingsMutex.RUnlock()
+
+	if !found || internalRec.temporary || !canList(r, keyName) {
+		http.NotFound(w, r)
+		return
+	}
+
+	host := replayHost(r, info, "")
+	if !isSafeHost(host) {
+		http.Error(w, "invalid host", http.StatusBadRequest)
+		return
+	}
+
+	if !isSafeKey(info.EncryptionKey) {
+		http.Error(w, "invalid encryption key", http.StatusBadRequest)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, launchArg{
+		GameDir:  gameDir,
+		Host:     host,
+		Key:      info.EncryptionKey,
+		GameID:   info.GameID,
+		Platform: info.Platform,
+	}); err != nil {
+		log.Println("launch script template error:", err)
+		http.Error(w, "internal server error",
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"watch_"+
+		filename+"\"")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
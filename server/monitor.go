@@ -1,150 +1,174 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
-	"net/http"
 	"os"
 	"path"
 	"runtime/debug"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/1lann/lol-replay/record"
 	"github.com/1lann/lol-replay/recording"
 )
 
-var platformToRegion = map[string]string{
-	"NA1":  "na",
-	"OC1":  "oce",
-	"EUN1": "eune",
-	"EUW1": "euw",
-	"KR":   "kr",
-	"BR1":  "br",
-	"LA1":  "lan",
-	"LA2":  "las",
-	"RU":   "ru",
-	"TR1":  "tr",
-	"PBE1": "pbe",
-}
-
+// gameInfoMetadata describes a game as it is stored in the user metadata of
+// recordings. Its fields mirror the spectator-v4 active game response, and
+// responses from other versions of the spectator API are mapped into it by
+// spectatorClient.
 type gameInfoMetadata struct {
-	BannedChampions []struct {
-		ChampionID int `json:"championId"`
-		PickTurn   int `json:"pickTurn"`
-		TeamID     int `json:"teamId"`
-	} `json:"bannedChampions"`
-	GameID            int64  `json:"gameId"`
-	GameLength        int    `json:"gameLength"`
-	GameMode          string `json:"gameMode"`
-	GameQueueConfigID int    `json:"gameQueueConfigId"`
-	GameStartTime     int64  `json:"gameStartTime"`
-	GameType          string `json:"gameType"`
-	MapID             int    `json:"mapId"`
+	BannedChampions   []bannedChampion `json:"bannedChampions"`
+	GameID            int64            `json:"gameId"`
+	GameLength        int              `json:"gameLength"`
+	GameMode          string           `json:"gameMode"`
+	GameQueueConfigID int              `json:"gameQueueConfigId"`
+	GameStartTime     int64            `json:"gameStartTime"`
+	GameType          string           `json:"gameType"`
+	MapID             int              `json:"mapId"`
 	Observers         struct {
 		EncryptionKey string `json:"encryptionKey"`
 	} `json:"observers"`
-	Participants []struct {
-		Bot        bool `json:"bot"`
-		ChampionID int  `json:"championId"`
-		Masteries  []struct {
-			MasteryID int `json:"masteryId"`
-			Rank      int `json:"rank"`
-		} `json:"masteries"`
-		ProfileIconID int `json:"profileIconId"`
-		Runes         []struct {
-			Count  int `json:"count"`
-			RuneID int `json:"runeId"`
-		} `json:"runes"`
-		Spell1Id     int    `json:"spell1Id"`
-		Spell2Id     int    `json:"spell2Id"`
-		SummonerID   string `json:"summonerId"`
-		SummonerName string `json:"summonerName"`
-		TeamID       int    `json:"teamId"`
-	} `json:"participants"`
-	PlatformID string `json:"platformId"`
+	Participants []gameParticipant `json:"participants"`
+	PlatformID   string            `json:"platformId"`
+}
+
+type bannedChampion struct {
+	ChampionID int `json:"championId"`
+	PickTurn   int `json:"pickTurn"`
+	TeamID     int `json:"teamId"`
+}
+
+// gameParticipant is a player in a game. Games recorded from spectator-v5
+// identify players by PUUID and Riot ID, and may not have a summoner ID.
+type gameParticipant struct {
+	Bot        bool `json:"bot"`
+	ChampionID int  `json:"championId"`
+	Masteries  []struct {
+		MasteryID int `json:"masteryId"`
+		Rank      int `json:"rank"`
+	} `json:"masteries"`
+	ProfileIconID int `json:"profileIconId"`
+	Runes         []struct {
+		Count  int `json:"count"`
+		RuneID int `json:"runeId"`
+	} `json:"runes"`
+	Spell1Id     int    `json:"spell1Id"`
+	Spell2Id     int    `json:"spell2Id"`
+	SummonerID   string `json:"summonerId"`
+	SummonerName string `json:"summonerName"`
+	PUUID        string `json:"puuid"`
+	RiotID       string `json:"riotId"`
+	TeamID       int    `json:"teamId"`
 }
 
+// idleMonitorWait is how long monitors wait before checking the
+// configuration again when they have nothing to monitor.
+const idleMonitorWait = time.Second * 10
+
+// monitorPlayers monitors the players in the configuration. The players are
+// read from the configuration at the start of every pass through them, so
+// that players added or removed when the configuration is reloaded are
+// picked up.
 func monitorPlayers() {
-	waitSeconds := float64(config.RefreshRate) / float64(len(config.Players))
-	waitPeriod := time.Millisecond * time.Duration(waitSeconds*1000.0)
 	log.Println("Monitoring...")
 
 	for {
-		for _, player := range config.Players {
+		conf := getConfig()
+		if len(conf.Players) == 0 {
+			time.Sleep(idleMonitorWait)
+			continue
+		}
+
+		waitSeconds := float64(conf.RefreshRate) / float64(len(conf.Players))
+		waitPeriod := time.Millisecond * time.Duration(waitSeconds*1000.0)
+
+		for _, player := range conf.Players {
 			time.Sleep(waitPeriod)
-			log.Println("Trying: " + player.ID)
-			info, ok := player.currentGameInfo(config.RiotAPIKey)
+			log.Println("Trying: " + player.name())
+			info, ok := player.currentGameInfo(conf.RiotAPIKey)
 
 			if !ok {
 				log.Println("Ops., got a problem...")
 				continue
 			}
 
-			gameID := strconv.FormatInt(info.GameID, 10)
-			keyName := info.PlatformID + "_" + gameID
-			resume := false
-
-			recordingsMutex.RLock()
+			startRecording(info)
+		}
+	}
+}
 
-			if _, found := recordings[keyName]; found {
-				if recordings[keyName].temporary ||
-					recordings[keyName].recording ||
-					recordings[keyName].rec.IsComplete() {
-					recordingsMutex.RUnlock()
-					continue
-				}
+// startRecording starts recording the game described by info in the
+// background, unless it is already being recorded or has already been
+// recorded completely. It returns whether or not a recording was started.
+func startRecording(info gameInfoMetadata) bool {
+	gameID := strconv.FormatInt(info.GameID, 10)
+	keyName := info.PlatformID + "_" + gameID
+	resume := false
 
-				if !recordings[keyName].rec.IsComplete() {
-					resume = true
-				}
-			}
+	recordingsMutex.Lock()
+	defer recordingsMutex.Unlock()
 
-			recordingsMutex.RUnlock()
-			recordingsMutex.Lock()
+	if internalRec, found := recordings[keyName]; found {
+		if internalRec.temporary || internalRec.recording ||
+			internalRec.entry.Complete {
+			return false
+		}
 
-			if !resume {
-				recordings[keyName] = &internalRecording{
-					temporary: true,
-					recording: false,
-				}
-			} else {
-				recordings[keyName].temporary = true
-				recordings[keyName].recording = false
-			}
+		resume = true
+	}
 
-			cleanUp()
-			recordingsMutex.Unlock()
-			go recordGame(info, resume)
+	if !resume {
+		recordings[keyName] = &internalRecording{
+			temporary: true,
+			recording: false,
 		}
+	} else {
+		recordings[keyName].temporary = true
+		recordings[keyName].recording = false
 	}
+
+	cleanUp()
+	go recordGame(info, resume)
+	return true
 }
 
-// recordingsMutex must be Locked before cleanUp is called.
-func cleanUp() {
-	for len(recordings) >= config.KeepNumRecordings {
-		deleteRecording := sortedRecordings[0]
+// removeRecording deletes a recording and its file. recordingsMutex must be
+// Locked before removeRecording is called.
+func removeRecording(deleteRecording *internalRecording) {
+	if deleteRecording.rec != nil {
 		deleteRecording.rec.Lock()
-		deleteRecording.temporary = true
+		defer deleteRecording.rec.Unlock()
 		deleteRecording.file.Close()
-		err := os.Remove(deleteRecording.location)
-		if err != nil {
-			log.Println("failed to delete "+
-				deleteRecording.location+":", err)
-		} else {
-			log.Println("deleted: " + deleteRecording.location)
-		}
+	}
 
-		deleteRecording.rec.Unlock()
+	deleteRecording.temporary = true
+	deleteRecording.removed = true
+	err := os.Remove(deleteRecording.location)
+	if err != nil {
+		log.Println("failed to delete "+
+			deleteRecording.location+":", err)
+	} else {
+		log.Println("deleted: " + deleteRecording.location)
+	}
 
-		sortedRecordings = sortedRecordings[1:]
+	for i, rec := range sortedRecordings {
+		if rec == deleteRecording {
+			sortedRecordings = append(sortedRecordings[:i],
+				sortedRecordings[i+1:]...)
+			break
+		}
+	}
 
-		for key, rec := range recordings {
-			if rec == deleteRecording {
-				delete(recordings, key)
-				break
+	for key, rec := range recordings {
+		if rec == deleteRecording {
+			delete(recordings, key)
+			deletedRecordings[key] = true
+			deleteIndexEntry(key)
+			if err := setPinned(key, false); err != nil {
+				log.Println("failed to unpin "+key+":", err)
 			}
+			break
 		}
 	}
 }
@@ -154,7 +178,7 @@ func loadRecordGameFile(resume bool,
 	var sortedKey = -1
 
 	if !resume {
-		file, err := os.Create(config.RecordingsDirectory + "/" + keyName + ".glr")
+		file, err := os.Create(getConfig().RecordingsDirectory + "/" + keyName + ".glr")
 		if err != nil {
 			log.Println("create recording error:", err)
 			return nil, nil, sortedKey, err
@@ -170,11 +194,20 @@ func loadRecordGameFile(resume bool,
 	}
 
 	recordingsMutex.RLock()
-	rec := recordings[keyName].rec
-	file := recordings[keyName].file
+	resumeRec := recordings[keyName]
+	recordingsMutex.RUnlock()
+
+	rec, err := openRecording(resumeRec)
+	if err != nil {
+		log.Println("failed to open recording to resume:", err)
+		return nil, nil, sortedKey, err
+	}
+
+	recordingsMutex.RLock()
+	file := resumeRec.file
 
 	for i, internalRec := range sortedRecordings {
-		if internalRec.rec == rec {
+		if internalRec == resumeRec {
 			sortedKey = i
 			break
 		}
@@ -193,7 +226,12 @@ func recordGame(info gameInfoMetadata, resume bool) {
 			log.Printf("record game panic: %s: %s", e, debug.Stack())
 
 			recordingsMutex.Lock()
-			recordings[keyName].recording = false
+			internalRec := recordings[keyName]
+			internalRec.recording = false
+			if internalRec.rec != nil {
+				internalRec.entry = newIndexEntry(internalRec.rec,
+					internalRec.file)
+			}
 			recordingsMutex.Unlock()
 		}
 	}()
@@ -208,10 +246,15 @@ func recordGame(info gameInfoMetadata, resume bool) {
 	recordingsMutex.Lock()
 	recordings[keyName] = &internalRecording{
 		file:      file,
-		location:  config.RecordingsDirectory + "/" + filename,
+		location:  getConfig().RecordingsDirectory + "/" + filename,
 		rec:       rec,
 		temporary: false,
 		recording: true,
+		summary:   newRecordingSummary(info),
+		entry: indexEntry{
+			Filename: filename,
+			Game:     &info,
+		},
 	}
 
 	if resume && sortedKey >= 0 {
@@ -238,7 +281,23 @@ func recordGame(info gameInfoMetadata, resume bool) {
 		info.Observers.EncryptionKey, rec)
 
 	recordingsMutex.Lock()
-	recordings[keyName].recording = false
+	internalRec := recordings[keyName]
+	internalRec.recording = false
+
+	if err != nil && !rec.HasGameMetadata() {
+		// Nothing was recorded, such as when a game requested by its
+		// game ID does not exist, so the game can be requested again.
+		log.Println("error while recording "+keyName+":", err)
+		log.Println("deleting empty recording: " + keyName)
+		removeRecording(internalRec)
+		delete(deletedRecordings, keyName)
+		recordingsMutex.Unlock()
+		return
+	}
+
+	internalRec.entry = newIndexEntry(rec, file)
+	putIndexEntry(keyName, internalRec.entry)
+	atomic.StoreInt64(&internalRec.lastUsed, time.Now().UnixNano())
 	recordingsMutex.Unlock()
 
 	if err != nil {
@@ -249,45 +308,103 @@ func recordGame(info gameInfoMetadata, resume bool) {
 	log.Println("recording " + keyName + " complete")
 }
 
-func (p configPlayer) currentGameInfo(apiKey string) (gameInfoMetadata, bool) {
-	url := "https://" + strings.ToLower(p.Platform) +
-		".api.riotgames.com/lol/spectator/v4/active-games/by-summoner/" + p.ID +
-		"?api_key=" + apiKey
+// riotAPIURL returns the URL of a path on the Riot API for a platform.
+func riotAPIURL(platform, path string) string {
+	info, _ := record.LookupPlatform(platform)
+	return info.APIURL(path)
+}
 
-	for i := 0; i < 3; i++ {
-		resp, err := http.Get(url)
+// name returns the name of a player to use in logs.
+func (p configPlayer) name() string {
+	if p.RiotID != "" {
+		return p.RiotID
+	}
 
-		if err != nil {
-			log.Println("URL:", url)
-			log.Println("current game error:", err)
-			continue
-		}
+	return p.ID
+}
 
-		if resp.StatusCode == http.StatusNotFound {
-			resp.Body.Close()
-			return gameInfoMetadata{}, false
-		}
+// summonerID returns the summoner ID of a player, resolving their Riot ID
+// if the player is configured with one.
+func (p configPlayer) summonerID(apiKey string) (string, error) {
+	if p.RiotID == "" {
+		return p.ID, nil
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			log.Println("URL:", url)
-			log.Println("current game: not OK:", resp.Status)
-			resp.Body.Close()
-			continue
-		}
+	resolved, err := riotIDs.resolve(p.Platform, p.RiotID, apiKey)
+	if err != nil {
+		return "", err
+	}
 
-		dec := json.NewDecoder(resp.Body)
-		var info gameInfoMetadata
-		err = dec.Decode(&info)
-		resp.Body.Close()
+	return resolved.SummonerID, nil
+}
 
-		if err != nil {
-			log.Println("URL:", url)
-			log.Println("current error:", err)
-			return gameInfoMetadata{}, false
-		}
+// puuid returns the PUUID of a player, resolving it from their Riot ID or
+// summoner ID.
+func (p configPlayer) puuid(apiKey string) (string, error) {
+	if p.RiotID == "" {
+		return riotIDs.resolveSummoner(p.Platform, p.ID, apiKey)
+	}
+
+	resolved, err := riotIDs.resolve(p.Platform, p.RiotID, apiKey)
+	if err != nil {
+		return "", err
+	}
+
+	return resolved.PUUID, nil
+}
+
+// spectatorID returns the ID which identifies a player in a version of the
+// spectator API.
+func (p configPlayer) spectatorID(version int, apiKey string) (string,
+	error) {
+	if version == spectatorV4 {
+		return p.summonerID(apiKey)
+	}
+
+	return p.puuid(apiKey)
+}
+
+// cachedPUUID returns the PUUID of a player without resolving it, which is
+// empty if it has not been resolved yet.
+func (p configPlayer) cachedPUUID() string {
+	apiKey := getConfig().RiotAPIKey
+	if p.RiotID == "" {
+		puuid, _ := riotIDs.lookupSummoner(p.Platform, p.ID, apiKey)
+		return puuid
+	}
+
+	resolved, _ := riotIDs.lookup(p.Platform, p.RiotID, apiKey)
+	return resolved.PUUID
+}
+
+// cachedSummonerID returns the summoner ID of a player without resolving
+// their Riot ID, which is empty if it has not been resolved yet.
+func (p configPlayer) cachedSummonerID() string {
+	if p.RiotID == "" {
+		return p.ID
+	}
+
+	resolved, _ := riotIDs.lookup(p.Platform, p.RiotID,
+		getConfig().RiotAPIKey)
+	return resolved.SummonerID
+}
+
+func (p configPlayer) currentGameInfo(apiKey string) (gameInfoMetadata, bool) {
+	client := newSpectatorClient(p.Platform, apiKey)
+
+	player, err := p.spectatorID(client.version, apiKey)
+	if err != nil {
+		log.Println("failed to resolve "+p.name()+":", err)
+		return gameInfoMetadata{}, false
+	}
 
-		return info, true
+	info, err := client.activeGame(player)
+	if err == errNotFound {
+		return gameInfoMetadata{}, false
+	} else if err != nil {
+		log.Println("current game error:", err)
+		return gameInfoMetadata{}, false
 	}
 
-	return gameInfoMetadata{}, false
+	return info, true
 }
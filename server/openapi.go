@@ -0,0 +1,304 @@
+package main
+
+// openAPIDocument is the OpenAPI description of version 1 of the REST API,
+// served at apiV1Path + "openapi.json".
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "LoL Replay API",
+    "version": "1.0.0",
+    "description": "Lists and manages the recordings of a LoL Replay server. Requests which modify recordings must be authenticated with the admin key of the server as a bearer token."
+  },
+  "servers": [{"url": "/api/v1"}],
+  "components": {
+    "securitySchemes": {
+      "adminKey": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "platform": {
+        "name": "platform", "in": "path", "required": true,
+        "description": "The platform ID of the game, such as NA1.",
+        "schema": {"type": "string"}
+      },
+      "gameID": {
+        "name": "gameID", "in": "path", "required": true,
+        "description": "The numeric ID of the game.",
+        "schema": {"type": "string", "pattern": "^[0-9]+$"}
+      },
+      "share": {
+        "name": "share", "in": "query",
+        "description": "The share code of an unlisted recording, from its share_link.",
+        "schema": {"type": "string"}
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "An error.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": ["status", "error"],
+        "properties": {
+          "status": {"type": "integer", "description": "The HTTP status code of the response."},
+          "error": {"type": "string", "description": "A description of the error."}
+        }
+      },
+      "Participant": {
+        "type": "object",
+        "properties": {
+          "summoner_name": {"type": "string"},
+          "summoner_id": {"type": "string"},
+          "puuid": {"type": "string"},
+          "riot_id": {"type": "string"},
+          "team_id": {"type": "integer"},
+          "champion_id": {"type": "integer"},
+          "champion_name": {"type": "string"},
+          "profile_icon_id": {"type": "integer"},
+          "spell1_id": {"type": "integer"},
+          "spell2_id": {"type": "integer"},
+          "bot": {"type": "boolean"}
+        }
+      },
+      "Ban": {
+        "type": "object",
+        "properties": {
+          "champion_id": {"type": "integer"},
+          "champion_name": {"type": "string"},
+          "team_id": {"type": "integer"},
+          "pick_turn": {"type": "integer"}
+        }
+      },
+      "Recording": {
+        "type": "object",
+        "properties": {
+          "key": {"type": "string", "example": "NA1_2345678901"},
+          "platform": {"type": "string"},
+          "game_id": {"type": "string"},
+          "region": {"type": "string"},
+          "version": {"type": "string", "description": "The version of the game client the game was played on."},
+          "status": {"type": "string", "enum": ["pending", "recording", "complete", "incomplete"], "description": "pending is only reported for a game requested with POST /record which has not received any data yet, in the response to the request and when the recording is retrieved on its own. Pending recordings are not listed."},
+          "visibility": {"type": "string", "enum": ["public", "unlisted", "private"]},
+          "pinned": {"type": "boolean", "description": "Pinned recordings are never removed by the retention policy."},
+          "share_link": {"type": "string", "description": "The link to an unlisted recording on the web interface, which includes its share code. Only unlisted recordings have a share link."},
+          "record_time": {"type": "string", "format": "date-time"},
+          "last_write_time": {"type": "string", "format": "date-time"},
+          "duration_seconds": {"type": "integer"},
+          "size_bytes": {"type": "integer"},
+          "queue": {"type": "string"},
+          "queue_id": {"type": "integer"},
+          "map_id": {"type": "integer"},
+          "game_mode": {"type": "string"},
+          "participants": {"type": "array", "items": {"$ref": "#/components/schemas/Participant"}},
+          "bans": {"type": "array", "items": {"$ref": "#/components/schemas/Ban"}},
+          "gaps": {
+            "type": "object",
+            "description": "The chunks and key frames which are missing from the recording.",
+            "properties": {
+              "chunks": {"type": "array", "items": {"type": "integer"}},
+              "key_frames": {"type": "array", "items": {"type": "integer"}}
+            }
+          },
+          "replay_string": {"type": "string"},
+          "launch_link": {"type": "string"},
+          "windows_script": {"type": "string"},
+          "mac_script": {"type": "string"}
+        }
+      },
+      "RecordingList": {
+        "type": "object",
+        "properties": {
+          "recordings": {"type": "array", "items": {"$ref": "#/components/schemas/Recording"}},
+          "next_cursor": {"type": "string", "description": "Passed as the cursor parameter to retrieve the next page. Missing on the last page."}
+        }
+      },
+      "RecordingPatch": {
+        "type": "object",
+        "additionalProperties": false,
+        "description": "Only the visibility and pin of a recording can be edited. The details of the game are stored in the recording and cannot be changed, and any other field is rejected.",
+        "properties": {
+          "visibility": {"type": "string", "enum": ["public", "unlisted", "private"]},
+          "pinned": {"type": "boolean"}
+        }
+      },
+      "RecordRequest": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["platform"],
+        "description": "A game given by game_id and encryption_key, or looked up as the game a summoner is currently in by riot_id or summoner_id.",
+        "properties": {
+          "platform": {"type": "string"},
+          "game_id": {"type": "string", "pattern": "^[0-9]+$"},
+          "encryption_key": {"type": "string", "description": "The encryption key of the game given by game_id, which is needed to play the recording. Required with game_id.", "pattern": "^[A-Za-z0-9+/=]+$"},
+          "riot_id": {"type": "string", "example": "Name#TAG"},
+          "summoner_id": {"type": "string"}
+        }
+      },
+      "Retention": {
+        "type": "object",
+        "properties": {
+          "recordings": {
+            "type": "array",
+            "description": "The recordings which would be removed, in the order they would be removed.",
+            "items": {
+              "type": "object",
+              "properties": {
+                "key": {"type": "string"},
+                "reason": {"type": "string", "example": "older than 30 days"},
+                "record_time": {"type": "string", "format": "date-time"},
+                "size_bytes": {"type": "integer"}
+              }
+            }
+          },
+          "total_bytes": {"type": "integer", "description": "The total size of the recordings which would be removed."}
+        }
+      }
+    }
+  },
+  "paths": {
+    "/recordings": {
+      "get": {
+        "summary": "List recordings from newest to oldest.",
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 20}},
+          {"name": "cursor", "in": "query", "schema": {"type": "string"}},
+          {"name": "summoner", "in": "query", "description": "A summoner ID or PUUID, or part of a summoner name or Riot ID, of a participant.", "schema": {"type": "string"}},
+          {"name": "champion", "in": "query", "description": "The ID or name of a champion played by a participant.", "schema": {"type": "string"}},
+          {"name": "queue", "in": "query", "description": "The queue ID of the game.", "schema": {"type": "integer"}},
+          {"name": "platform", "in": "query", "schema": {"type": "string"}},
+          {"name": "from", "in": "query", "description": "Only recordings started on or after this date or time.", "schema": {"type": "string", "example": "2024-01-31"}},
+          {"name": "to", "in": "query", "description": "Only recordings started on or before this date, or before this time.", "schema": {"type": "string", "example": "2024-02-29"}},
+          {"name": "complete", "in": "query", "schema": {"type": "boolean"}},
+          {"name": "status", "in": "query", "schema": {"type": "string", "enum": ["recording", "complete", "incomplete"]}},
+          {"$ref": "#/components/parameters/share"}
+        ],
+        "responses": {
+          "200": {
+            "description": "A page of recordings.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RecordingList"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Upload a recording file.",
+        "description": "The recording is stored under the platform and game ID it contains, and must not already exist. The retention policy is applied after the recording is added, and a conflict is returned if it removes the uploaded recording.",
+        "security": [{"adminKey": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}
+        },
+        "responses": {
+          "201": {
+            "description": "The uploaded recording.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Recording"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/recordings/{platform}/{gameID}/file": {
+      "parameters": [
+        {"$ref": "#/components/parameters/platform"},
+        {"$ref": "#/components/parameters/gameID"}
+      ],
+      "get": {
+        "summary": "Download the file of a recording.",
+        "security": [{"adminKey": []}],
+        "responses": {
+          "200": {
+            "description": "The .glr file of the recording.",
+            "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/record": {
+      "post": {
+        "summary": "Start recording a game.",
+        "security": [{"adminKey": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RecordRequest"}}}
+        },
+        "responses": {
+          "202": {
+            "description": "The game is being recorded. The recording is returned with the pending status.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Recording"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/retention": {
+      "get": {
+        "summary": "Report the recordings which the next cleanup by the retention policy would remove, without removing them.",
+        "security": [{"adminKey": []}],
+        "responses": {
+          "200": {
+            "description": "The recordings which would be removed.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Retention"}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/recordings/{platform}/{gameID}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/platform"},
+        {"$ref": "#/components/parameters/gameID"}
+      ],
+      "get": {
+        "summary": "Get a recording.",
+        "parameters": [{"$ref": "#/components/parameters/share"}],
+        "responses": {
+          "200": {
+            "description": "The recording.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Recording"}}}
+          },
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "summary": "Edit the visibility or pin of a recording.",
+        "security": [{"adminKey": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RecordingPatch"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The edited recording.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Recording"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a recording.",
+        "security": [{"adminKey": []}],
+        "responses": {
+          "204": {"description": "The recording was deleted."},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often the configuration file is checked for
+// changes.
+const configPollInterval = time.Second * 5
+
+// watchConfiguration reloads the configuration at location when the server
+// receives SIGHUP, or when the file is modified.
+func watchConfiguration(location string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var lastModified time.Time
+	if stat, err := os.Stat(location); err == nil {
+		lastModified = stat.ModTime()
+	}
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			log.Println("received SIGHUP, reloading configuration")
+		case <-ticker.C:
+			stat, err := os.Stat(location)
+			if err != nil || stat.ModTime().Equal(lastModified) {
+				continue
+			}
+
+			lastModified = stat.ModTime()
+			log.Println("configuration changed, reloading")
+		}
+
+		if err := reloadConfiguration(location); err != nil {
+			log.Println("failed to reload configuration, keeping the "+
+				"current configuration:", err)
+			continue
+		}
+
+		log.Println("configuration reloaded")
+	}
+}
+
+// reloadConfiguration reads the configuration at location and replaces the
+// current configuration with it if it is valid. Settings which are only
+// used when the server starts keep their current values until the server
+// is restarted, and the new configuration is validated with those values.
+// The current configuration is kept if an error is returned.
+func reloadConfiguration(location string) error {
+	newConfig, err := decodeConfiguration(location)
+	if err != nil {
+		return err
+	}
+
+	current := getConfig()
+	if newConfig.BindAddress != current.BindAddress ||
+		newConfig.RecordingsDirectory != current.RecordingsDirectory ||
+		newConfig.LiveDelaySeconds != current.LiveDelaySeconds ||
+		newConfig.Proxy != current.Proxy ||
+		newConfig.TokenSecret != current.TokenSecret {
+		log.Println("changes to bind_address, recordings_directory, " +
+			"live_delay_seconds, proxy and token_secret require a restart")
+	}
+
+	newConfig.BindAddress = current.BindAddress
+	newConfig.RecordingsDirectory = current.RecordingsDirectory
+	newConfig.LiveDelaySeconds = current.LiveDelaySeconds
+	newConfig.Proxy = current.Proxy
+	newConfig.TokenSecret = current.TokenSecret
+
+	if err := newConfig.validate(); err != nil {
+		return err
+	}
+
+	if err := newConfig.usePlatforms(); err != nil {
+		return err
+	}
+
+	setConfig(newConfig)
+	return nil
+}
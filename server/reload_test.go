@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/1lann/lol-replay/record"
+)
+
+func TestReloadConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldConfig := getConfig()
+	oldPlatforms := record.Platforms()
+	t.Cleanup(func() {
+		setConfig(oldConfig)
+		if err := record.SetPlatforms(oldPlatforms); err != nil {
+			t.Error(err)
+		}
+	})
+
+	location := dir + "/config.json"
+	write := func(data string) {
+		if err := ioutil.WriteFile(location, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"recordings_directory": "recordings", "bind_address": ":9001",
+		"riot_api_key": "a", "keep_num_recordings": 10,
+		"show_per_page": 20}`)
+
+	conf, err := readConfiguration(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conf.usePlatforms(); err != nil {
+		t.Fatal(err)
+	}
+	setConfig(conf)
+
+	// Settings which require a restart keep their current values.
+	write(`{"recordings_directory": "other", "bind_address": ":9002",
+		"riot_api_key": "b", "keep_num_recordings": 5, "show_per_page": 20,
+		"platforms": [{"id": "TEST2", "spectator_url": "http://127.0.0.1:1"}]}`)
+
+	if err := reloadConfiguration(location); err != nil {
+		t.Fatal(err)
+	}
+
+	current := getConfig()
+	if current.RiotAPIKey != "b" || current.KeepNumRecordings != 5 ||
+		current.BindAddress != ":9001" ||
+		current.RecordingsDirectory != "recordings" {
+		t.Errorf("unexpected reloaded configuration: %+v", current)
+	}
+
+	if _, found := record.LookupPlatform("TEST2"); !found {
+		t.Error("platform of reloaded configuration is not registered")
+	}
+
+	// The token secret is not changed by a reload, so a reload cannot make
+	// recordings private by default on a server without tokens.
+	write(`{"recordings_directory": "recordings", "bind_address": ":9001",
+		"riot_api_key": "c", "keep_num_recordings": 10, "show_per_page": 20,
+		"token_secret": "secret", "default_visibility": "private"}`)
+
+	if err := reloadConfiguration(location); err == nil {
+		t.Error("reload enabled private recordings without a token secret")
+	}
+
+	// Invalid configurations leave the configuration and platforms as they
+	// were.
+	write(`{"recordings_directory": "recordings", "bind_address": ":9001",
+		"riot_api_key": "c", "keep_num_recordings": 10, "show_per_page": 20,
+		"platforms": [{"id": "TEST3", "spectator_url": "http://127.0.0.1:1"}],
+		"players": [{"riot_id": "Name#TAG", "platform": "NOPE"}]}`)
+
+	if err := reloadConfiguration(location); err == nil {
+		t.Error("invalid configuration was reloaded")
+	}
+
+	write(`{"recordings_directory": "recordings"`)
+	if err := reloadConfiguration(location); err == nil {
+		t.Error("malformed configuration was reloaded")
+	}
+
+	if getConfig() != current || current.TokenSecret != "" ||
+		current.DefaultVisibility != "" {
+		t.Errorf("configuration changed by failed reloads: %+v", getConfig())
+	}
+
+	if _, found := record.LookupPlatform("TEST3"); found {
+		t.Error("platform of invalid configuration was registered")
+	}
+
+	if _, found := record.LookupPlatform("TEST2"); !found {
+		t.Error("platform was removed by invalid configuration")
+	}
+
+	// Platforms which are removed from the configuration are unregistered.
+	write(`{"recordings_directory": "recordings", "bind_address": ":9001",
+		"riot_api_key": "c", "keep_num_recordings": 10, "show_per_page": 20}`)
+
+	if err := reloadConfiguration(location); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := record.LookupPlatform("TEST2"); found {
+		t.Error("removed platform is still registered")
+	}
+}
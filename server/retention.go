@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recordings are removed by the retention policy when a recording starts,
+// and every retentionInterval so that recordings expire by age even if no
+// games are being recorded. Pinned recordings are never removed by the
+// retention policy, and do not count towards its limits.
+const (
+	pinsFile          = "pinned.json"
+	retentionInterval = time.Hour
+)
+
+var pins = make(map[string]bool)
+var pinsMutex = new(sync.RWMutex)
+
+// retentionRemoval is a recording which the retention policy removes, and
+// the reason it is removed.
+type retentionRemoval struct {
+	key      string
+	reason   string
+	internal *internalRecording
+	entry    indexEntry
+}
+
+// loadPins reads the pinned recordings from the recordings directory.
+func loadPins() {
+	data, err := ioutil.ReadFile(getConfig().RecordingsDirectory + "/" +
+		pinsFile)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Println("failed to read pinned recordings:", err)
+		return
+	}
+
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+
+	if err := json.Unmarshal(data, &pins); err != nil {
+		log.Println("failed to read pinned recordings:", err)
+	}
+}
+
+// isPinned returns whether or not a recording is pinned by its key.
+func isPinned(keyName string) bool {
+	pinsMutex.RLock()
+	defer pinsMutex.RUnlock()
+
+	return pins[keyName]
+}
+
+// setPinned pins or unpins a recording by its key, and saves the pinned
+// recordings.
+func setPinned(keyName string, pinned bool) error {
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+
+	if pins[keyName] == pinned {
+		return nil
+	}
+
+	if pinned {
+		pins[keyName] = true
+	} else {
+		delete(pins, keyName)
+	}
+
+	data, err := json.Marshal(pins)
+	if err != nil {
+		return err
+	}
+
+	location := getConfig().RecordingsDirectory + "/" + pinsFile
+	if err := ioutil.WriteFile(location+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(location+".tmp", location)
+}
+
+// matches returns whether or not a recording is subject to a retention
+// rule.
+func (rule configRetentionRule) matches(summary *recordingSummary) bool {
+	if summary == nil {
+		return false
+	}
+
+	if rule.Queue != nil &&
+		(!summary.hasQueue || summary.queueID != *rule.Queue) {
+		return false
+	}
+
+	if rule.Player != "" && !summary.hasParticipant(rule.Player) {
+		return false
+	}
+
+	return true
+}
+
+// description returns a description of the recordings a retention rule
+// applies to, to use in the reasons of removals.
+func (rule configRetentionRule) description() string {
+	var parts []string
+	if rule.Player != "" {
+		parts = append(parts, "with "+rule.Player)
+	}
+
+	if rule.Queue != nil {
+		parts = append(parts, "in queue "+strconv.Itoa(*rule.Queue))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// retentionRule returns the index of the first retention rule a recording
+// is subject to, or -1 if it is not subject to a rule.
+func retentionRule(conf *configuration, internalRec *internalRecording) int {
+	for i, rule := range conf.Retention.Rules {
+		if rule.matches(internalRec.summary) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+
+	return strconv.Itoa(n) + " " + unit + "s"
+}
+
+// retentionPlan returns the recordings which the retention policy of conf
+// removes at now, in the order they are removed. Recordings older than their
+// maximum age are removed first, followed by the oldest recordings of each
+// rule with more than its maximum number of recordings, and then the oldest
+// recordings until there are at most keep_num_recordings recordings and
+// they use at most max_total_bytes. Recordings which are pinned or in
+// progress are never removed. recordingsMutex must be RLocked before
+// retentionPlan is called.
+func retentionPlan(conf *configuration, now time.Time) []retentionRemoval {
+	keys := make(map[*internalRecording]string)
+	for key, internalRec := range recordings {
+		keys[internalRec] = key
+	}
+
+	// kept holds the recordings which count towards the limits, from
+	// oldest to newest. Recordings which are about to start are not sorted
+	// yet, and are the newest.
+	var kept []retentionRemoval
+	sorted := make(map[*internalRecording]bool)
+	for _, internalRec := range sortedRecordings {
+		sorted[internalRec] = true
+		key := keys[internalRec]
+		if !isPinned(key) {
+			kept = append(kept, retentionRemoval{
+				key:      key,
+				internal: internalRec,
+				entry:    internalRec.current(),
+			})
+		}
+	}
+
+	for key, internalRec := range recordings {
+		if !sorted[internalRec] && !isPinned(key) {
+			kept = append(kept, retentionRemoval{
+				key:      key,
+				internal: internalRec,
+			})
+		}
+	}
+
+	removed := make(map[*internalRecording]bool)
+	var plan []retentionRemoval
+
+	remove := func(item retentionRemoval, reason string) {
+		item.reason = reason
+		removed[item.internal] = true
+		plan = append(plan, item)
+	}
+
+	removable := func(item retentionRemoval) bool {
+		return !removed[item.internal] && !item.internal.temporary &&
+			!item.internal.recording
+	}
+
+	rules := make([]int, len(kept))
+	for i, item := range kept {
+		rules[i] = retentionRule(conf, item.internal)
+	}
+
+	for i, item := range kept {
+		maxAge := conf.Retention.MaxAgeDays
+		if rules[i] >= 0 && conf.Retention.Rules[rules[i]].MaxAgeDays > 0 {
+			maxAge = conf.Retention.Rules[rules[i]].MaxAgeDays
+		}
+
+		if maxAge > 0 && removable(item) &&
+			now.Sub(item.entry.Info.RecordTime) >
+				time.Duration(maxAge)*24*time.Hour {
+			remove(item, "older than "+pluralize(maxAge, "day"))
+		}
+	}
+
+	for ruleIndex, rule := range conf.Retention.Rules {
+		if rule.MaxCount <= 0 {
+			continue
+		}
+
+		count := 0
+		for i, item := range kept {
+			if rules[i] == ruleIndex && !removed[item.internal] {
+				count++
+			}
+		}
+
+		for i, item := range kept {
+			if count <= rule.MaxCount {
+				break
+			}
+
+			if rules[i] == ruleIndex && removable(item) {
+				remove(item, "more than "+pluralize(rule.MaxCount,
+					"recording")+" "+rule.description())
+				count--
+			}
+		}
+	}
+
+	count := 0
+	var totalBytes int64
+	for _, item := range kept {
+		if !removed[item.internal] {
+			count++
+			totalBytes += item.entry.FileSize
+		}
+	}
+
+	for _, item := range kept {
+		if count <= conf.KeepNumRecordings {
+			break
+		}
+
+		if removable(item) {
+			remove(item, "more than "+pluralize(conf.KeepNumRecordings,
+				"recording"))
+			count--
+			totalBytes -= item.entry.FileSize
+		}
+	}
+
+	if conf.Retention.MaxTotalBytes > 0 {
+		for _, item := range kept {
+			if totalBytes <= conf.Retention.MaxTotalBytes {
+				break
+			}
+
+			if removable(item) {
+				remove(item, "recordings use more than "+
+					strconv.FormatInt(conf.Retention.MaxTotalBytes, 10)+
+					" bytes")
+				totalBytes -= item.entry.FileSize
+			}
+		}
+	}
+
+	return plan
+}
+
+// cleanUp removes the recordings which the retention policy removes.
+// recordingsMutex must be Locked before cleanUp is called.
+func cleanUp() {
+	for _, removal := range retentionPlan(getConfig(), time.Now()) {
+		log.Println("retention policy removing " + removal.key + ": " +
+			removal.reason)
+		removeRecording(removal.internal)
+	}
+}
+
+// enforceRetention periodically removes the recordings which the retention
+// policy removes.
+func enforceRetention() {
+	for {
+		time.Sleep(retentionInterval)
+
+		recordingsMutex.Lock()
+		cleanUp()
+		recordingsMutex.Unlock()
+	}
+}
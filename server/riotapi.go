@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests to the Riot API are made at most maxRiotAPIAttempts times. They
+// are retried after 429 Too Many Requests responses, server errors and
+// network errors, waiting for the Retry-After header of the response, or
+// for riotAPIBackoff doubled after every attempt.
+//
+// Until the rate limits of an API key are known from the X-App-Rate-Limit
+// header, the rate limits of development keys are assumed.
+const (
+	maxRiotAPIAttempts  = 3
+	riotAPIBackoff      = time.Second
+	defaultAppRateLimit = "20:1,100:120"
+)
+
+var errNotFound = errors.New("not found")
+
+// rateWindow is a rate limit of limit requests every period.
+type rateWindow struct {
+	limit  int
+	period time.Duration
+	start  time.Time
+	count  int
+}
+
+// rateLimiter tracks the requests made against a set of rate limits, as
+// described by a Riot API rate limit header.
+type rateLimiter struct {
+	windows      []*rateWindow
+	blockedUntil time.Time
+}
+
+// parseRateLimits parses a Riot API rate limit header such as
+// "20:1,100:120", which is a list of counts and their periods in seconds.
+func parseRateLimits(header string) map[time.Duration]int {
+	limits := make(map[time.Duration]int)
+	for _, limit := range strings.Split(header, ",") {
+		parts := strings.Split(strings.TrimSpace(limit), ":")
+		if len(parts) != 2 {
+			continue
+		}
+
+		count, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(parts[1])
+		if err != nil || seconds <= 0 {
+			continue
+		}
+
+		limits[time.Duration(seconds)*time.Second] = count
+	}
+
+	return limits
+}
+
+// update replaces the rate limits of the limiter with limits, keeping the
+// progress through the limits that are unchanged. counts is the number of
+// requests the Riot API has counted in each period, which may include
+// requests made by other servers using the same API key.
+func (l *rateLimiter) update(limits, counts string, now time.Time) {
+	parsedLimits := parseRateLimits(limits)
+	if len(parsedLimits) == 0 {
+		return
+	}
+
+	parsedCounts := parseRateLimits(counts)
+
+	windows := make([]*rateWindow, 0, len(parsedLimits))
+	for period, limit := range parsedLimits {
+		var window *rateWindow
+		for _, existing := range l.windows {
+			if existing.period == period {
+				window = existing
+				break
+			}
+		}
+
+		if window == nil {
+			window = &rateWindow{period: period, start: now}
+		}
+
+		window.limit = limit
+		if count := parsedCounts[period]; count > window.count {
+			window.count = count
+		}
+
+		windows = append(windows, window)
+	}
+
+	l.windows = windows
+}
+
+// wait returns how long to wait until a request can be made.
+func (l *rateLimiter) wait(now time.Time) time.Duration {
+	var wait time.Duration
+	if now.Before(l.blockedUntil) {
+		wait = l.blockedUntil.Sub(now)
+	}
+
+	for _, window := range l.windows {
+		end := window.start.Add(window.period)
+		if now.Before(end) && window.count >= window.limit &&
+			end.Sub(now) > wait {
+			wait = end.Sub(now)
+		}
+	}
+
+	return wait
+}
+
+// take counts a request made at now against the rate limits.
+func (l *rateLimiter) take(now time.Time) {
+	for _, window := range l.windows {
+		if !now.Before(window.start.Add(window.period)) {
+			window.start = now
+			window.count = 0
+		}
+
+		window.count++
+	}
+}
+
+// riotAPIClient makes requests to the Riot API while staying within the
+// rate limits of the API keys that are used. Every API key has application
+// rate limits and method rate limits on each host, which are learnt from
+// the X-App-Rate-Limit and X-Method-Rate-Limit headers of responses.
+// Requests which would exceed the rate limits are queued until they can be
+// made. It is safe for concurrent use.
+type riotAPIClient struct {
+	client   *http.Client
+	limiters map[string]*rateLimiter
+	queues   map[string]*sync.Mutex
+	mutex    *sync.Mutex
+}
+
+var riotAPI = newRiotAPIClient(http.DefaultClient)
+
+func newRiotAPIClient(client *http.Client) *riotAPIClient {
+	return &riotAPIClient{
+		client:   client,
+		limiters: make(map[string]*rateLimiter),
+		queues:   make(map[string]*sync.Mutex),
+		mutex:    new(sync.Mutex),
+	}
+}
+
+// limiter returns the rate limiter for key, creating it with the rate
+// limits described by limits if it does not exist. The mutex must be
+// locked before limiter is called.
+func (c *riotAPIClient) limiter(key, limits string) *rateLimiter {
+	limiter, found := c.limiters[key]
+	if !found {
+		limiter = new(rateLimiter)
+		limiter.update(limits, "", time.Now())
+		c.limiters[key] = limiter
+	}
+
+	return limiter
+}
+
+func (c *riotAPIClient) queue(key string) *sync.Mutex {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	queue, found := c.queues[key]
+	if !found {
+		queue = new(sync.Mutex)
+		c.queues[key] = queue
+	}
+
+	return queue
+}
+
+// reserve blocks until a request can be made within the application rate
+// limits at appKey and the method rate limits at methodKey. Requests with
+// the same application rate limits wait in turn, but requests only join
+// the queue once their method rate limits allow them to be made, so that a
+// method which is rate limited does not hold up requests to other methods.
+func (c *riotAPIClient) reserve(appKey, methodKey string) {
+	for {
+		c.mutex.Lock()
+		wait := c.limiter(methodKey, "").wait(time.Now())
+		c.mutex.Unlock()
+
+		if wait > 0 {
+			time.Sleep(wait)
+			continue
+		}
+
+		if c.reserveInQueue(appKey, methodKey) {
+			return
+		}
+	}
+}
+
+// reserveInQueue waits in turn until a request can be made within the
+// application rate limits at appKey, and reserves the request. False is
+// returned without reserving the request if the method rate limits at
+// methodKey no longer allow it to be made.
+func (c *riotAPIClient) reserveInQueue(appKey, methodKey string) bool {
+	queue := c.queue(appKey)
+	queue.Lock()
+	defer queue.Unlock()
+
+	for {
+		c.mutex.Lock()
+		now := time.Now()
+		app := c.limiter(appKey, defaultAppRateLimit)
+		method := c.limiter(methodKey, "")
+
+		if method.wait(now) > 0 {
+			c.mutex.Unlock()
+			return false
+		}
+
+		wait := app.wait(now)
+		if wait <= 0 {
+			app.take(now)
+			method.take(now)
+			c.mutex.Unlock()
+			return true
+		}
+
+		c.mutex.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// updateLimits updates the rate limits at appKey and methodKey from the
+// headers of resp. If the response is 429 Too Many Requests, the rate limit
+// which was exceeded is blocked for the duration given by the Retry-After
+// header, or for backoff if it is missing.
+func (c *riotAPIClient) updateLimits(appKey, methodKey string,
+	resp *http.Response, backoff time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	app := c.limiter(appKey, defaultAppRateLimit)
+	method := c.limiter(methodKey, "")
+
+	app.update(resp.Header.Get("X-App-Rate-Limit"),
+		resp.Header.Get("X-App-Rate-Limit-Count"), now)
+	method.update(resp.Header.Get("X-Method-Rate-Limit"),
+		resp.Header.Get("X-Method-Rate-Limit-Count"), now)
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil &&
+		seconds >= 0 {
+		backoff = time.Duration(seconds) * time.Second
+	}
+
+	// Rate limits enforced by the service rather than the API key are
+	// treated as method rate limits, as they only apply to one API.
+	if resp.Header.Get("X-Rate-Limit-Type") == "application" {
+		app.blockedUntil = now.Add(backoff)
+	} else {
+		method.blockedUntil = now.Add(backoff)
+	}
+}
+
+// get requests rawURL, which is a request to method of the Riot API, and
+// decodes the response into v. Methods are named by the caller, and
+// requests with the same method name share method rate limits. errNotFound
+// is returned if the API responds with 404 Not Found.
+func (c *riotAPIClient) get(method, rawURL string, v interface{}) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	appKey := u.Query().Get("api_key") + "@" + u.Host
+	methodKey := appKey + "/" + method
+
+	backoff := riotAPIBackoff
+	for attempt := 1; ; attempt++ {
+		c.reserve(appKey, methodKey)
+
+		resp, err := c.client.Get(rawURL)
+		if err != nil {
+			if attempt >= maxRiotAPIAttempts {
+				return err
+			}
+
+			time.Sleep(backoff)
+			backoff *= 2
+			continue
+		}
+
+		c.updateLimits(appKey, methodKey, resp, backoff)
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			err := json.NewDecoder(resp.Body).Decode(v)
+			resp.Body.Close()
+			return err
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return errNotFound
+		case resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode >= http.StatusInternalServerError:
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+
+			if attempt >= maxRiotAPIAttempts {
+				return errors.New("not OK: " + resp.Status)
+			}
+
+			// Rate limited requests wait in reserve until the rate
+			// limit is no longer blocked.
+			if resp.StatusCode != http.StatusTooManyRequests {
+				time.Sleep(backoff)
+			}
+			backoff *= 2
+		default:
+			resp.Body.Close()
+			return errors.New("not OK: " + resp.Status)
+		}
+	}
+}
+
+// getRiotAPI decodes the response of a request to method of the Riot API
+// into v, using the shared Riot API client.
+func getRiotAPI(method, rawURL string, v interface{}) error {
+	return riotAPI.get(method, rawURL, v)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1lann/lol-replay/record"
+)
+
+// fakeRiotAPI is a stand-in for the Riot API of the TEST1 platform, which
+// is registered until the end of the test.
+type fakeRiotAPI struct {
+	*httptest.Server
+	mutex    *sync.Mutex
+	requests map[string]int
+}
+
+// newFakeRiotAPI starts a fake Riot API which counts the requests to each
+// path before they are served by handler. The server is closed and the
+// registered platforms are restored when the test ends.
+func newFakeRiotAPI(t *testing.T, handler http.HandlerFunc) *fakeRiotAPI {
+	api := &fakeRiotAPI{
+		mutex:    new(sync.Mutex),
+		requests: make(map[string]int),
+	}
+
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		api.mutex.Lock()
+		api.requests[r.URL.EscapedPath()]++
+		api.mutex.Unlock()
+
+		handler(w, r)
+	}))
+
+	oldPlatforms := record.Platforms()
+	t.Cleanup(func() {
+		api.Close()
+		if err := record.SetPlatforms(oldPlatforms); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if err := record.RegisterPlatform(record.Platform{
+		ID:           "TEST1",
+		SpectatorURL: api.URL,
+		APIHost:      api.URL,
+		RoutingHost:  api.URL,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return api
+}
+
+// count returns the number of requests made to path.
+func (api *fakeRiotAPI) count(path string) int {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.requests[path]
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits := parseRateLimits("20:1, 100:120,bad,5:x,3:0")
+	if len(limits) != 2 || limits[time.Second] != 20 ||
+		limits[120*time.Second] != 100 {
+		t.Error("unexpected rate limits:", limits)
+	}
+
+	if limits := parseRateLimits(""); len(limits) != 0 {
+		t.Error("unexpected rate limits:", limits)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := new(rateLimiter)
+	limiter.update("3:1,10:10", "2:1,2:10", now)
+
+	if wait := limiter.wait(now); wait != 0 {
+		t.Fatal("limiter with requests left waits", wait)
+	}
+
+	limiter.take(now)
+	if wait := limiter.wait(now); wait != time.Second {
+		t.Fatal("exhausted limiter waits", wait)
+	}
+
+	// Updating the limits keeps the requests counted so far.
+	limiter.update("3:1,10:10", "", now)
+	if wait := limiter.wait(now); wait != time.Second {
+		t.Fatal("updated limiter waits", wait)
+	}
+
+	if wait := limiter.wait(now.Add(time.Second)); wait != 0 {
+		t.Fatal("limiter waits after its window", wait)
+	}
+
+	limiter.blockedUntil = now.Add(5 * time.Second)
+	if wait := limiter.wait(now); wait != 5*time.Second {
+		t.Fatal("blocked limiter waits", wait)
+	}
+}
+
+func TestRiotAPIRetryAfter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.Header().Set("X-Rate-Limit-Type", "method")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write([]byte(`{"value":1}`))
+	}))
+	defer server.Close()
+
+	client := newRiotAPIClient(server.Client())
+	start := time.Now()
+
+	var result struct {
+		Value int `json:"value"`
+	}
+	if err := client.get("method", server.URL+"/?api_key=key",
+		&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Value != 1 || atomic.LoadInt32(&requests) != 2 {
+		t.Error("unexpected result", result.Value, "after", requests,
+			"requests")
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Error("request was retried after", elapsed)
+	}
+}
+
+func TestRiotAPINotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client := newRiotAPIClient(server.Client())
+	if err := client.get("method", server.URL+"/?api_key=key",
+		new(interface{})); err != errNotFound {
+		t.Error("expected not found, got:", err)
+	}
+}
+
+// TestRiotAPIQueue checks that concurrent requests are queued to stay
+// within the application rate limits learnt from the responses.
+func TestRiotAPIQueue(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Header().Set("X-App-Rate-Limit", "2:1,100:120")
+		w.Header().Set("X-Method-Rate-Limit", "100:10")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := newRiotAPIClient(server.Client())
+	if err := client.get("method", server.URL+"/?api_key=key",
+		new(interface{})); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.get("method", server.URL+"/?api_key=key",
+				new(interface{})); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// 5 requests at 2 per second need 3 windows.
+	if elapsed := time.Since(start); elapsed < 1900*time.Millisecond {
+		t.Error("requests were not rate limited, took", elapsed)
+	}
+}
+
+// TestRiotAPIMethodLimit checks that a method which is rate limited does
+// not hold up requests to other methods with the same API key.
+func TestRiotAPIMethodLimit(t *testing.T) {
+	limited := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path == "/limited" {
+			blocked := false
+			once.Do(func() { blocked = true })
+			if blocked {
+				w.Header().Set("Retry-After", "2")
+				w.Header().Set("X-Rate-Limit-Type", "method")
+				w.WriteHeader(http.StatusTooManyRequests)
+				close(limited)
+				return
+			}
+		}
+
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := newRiotAPIClient(server.Client())
+
+	done := make(chan error)
+	go func() {
+		done <- client.get("limited", server.URL+"/limited?api_key=key",
+			new(interface{}))
+	}()
+
+	<-limited
+	// Give the limited request time to start waiting for its rate limit.
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if err := client.get("other", server.URL+"/other?api_key=key",
+		new(interface{})); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("request to another method waited", elapsed)
+	}
+
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
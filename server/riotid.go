@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1lann/lol-replay/record"
+)
+
+// Riot IDs are resolved again after riotIDRefreshInterval, as players can
+// change their Riot ID at any time. Resolved Riot IDs are cached in
+// riotIDCacheFile in the recordings directory, so that they do not need to
+// be resolved every time the server starts.
+const (
+	riotIDRefreshInterval = time.Hour * 24
+	riotIDCacheFile       = "riot_ids.json"
+)
+
+type riotAccount struct {
+	PUUID    string `json:"puuid"`
+	GameName string `json:"gameName"`
+	TagLine  string `json:"tagLine"`
+}
+
+type summonerInfo struct {
+	ID    string `json:"id"`
+	PUUID string `json:"puuid"`
+}
+
+type resolvedPlayer struct {
+	PUUID      string    `json:"puuid"`
+	SummonerID string    `json:"summoner_id"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// riotIDCache resolves the Riot IDs of players to their PUUIDs and
+// summoner IDs, and caches them on disk. PUUIDs and summoner IDs are
+// encrypted differently for each API key, so players are cached for the API
+// key they were resolved with, and the players of other API keys are
+// dropped when the cache is saved. It is safe for concurrent use.
+type riotIDCache struct {
+	location string
+	players  map[string]resolvedPlayer
+	mutex    *sync.Mutex
+}
+
+var riotIDs *riotIDCache
+
+// newRiotIDCache returns a riotIDCache which is stored at location, loading
+// any Riot IDs that have already been resolved.
+func newRiotIDCache(location string) *riotIDCache {
+	c := &riotIDCache{
+		location: location,
+		players:  make(map[string]resolvedPlayer),
+		mutex:    new(sync.Mutex),
+	}
+
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("failed to read Riot ID cache:", err)
+		}
+		return c
+	}
+
+	if err := json.Unmarshal(data, &c.players); err != nil {
+		log.Println("failed to read Riot ID cache:", err)
+	}
+
+	return c
+}
+
+// apiKeyHash returns a short hash of an API key, which identifies the API
+// key in the cache without storing the API key on disk.
+func apiKeyHash(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+func riotIDKey(apiKey, platform, riotID string) string {
+	return apiKeyHash(apiKey) + "/" + platform + "/" +
+		strings.ToLower(riotID)
+}
+
+// summonerKey returns the key of a player identified by summoner ID, which
+// never collides with a Riot ID as game names cannot contain slashes.
+func summonerKey(apiKey, platform, summonerID string) string {
+	return apiKeyHash(apiKey) + "/" + platform + "/id/" + summonerID
+}
+
+// splitRiotID splits a Riot ID such as Name#TAG into its game name and tag
+// line.
+func splitRiotID(riotID string) (string, string, bool) {
+	sep := strings.LastIndex(riotID, "#")
+	if sep <= 0 || sep == len(riotID)-1 {
+		return "", "", false
+	}
+
+	return riotID[:sep], riotID[sep+1:], true
+}
+
+// lookup returns the Riot ID of a player resolved with apiKey from the
+// cache, without resolving it.
+func (c *riotIDCache) lookup(platform, riotID,
+	apiKey string) (resolvedPlayer, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	player, found := c.players[riotIDKey(apiKey, platform, riotID)]
+	return player, found
+}
+
+// resolve returns the PUUID and summoner ID of a player from their Riot ID.
+// Riot IDs are resolved through the account API of the platform's routing
+// host, and the summoner API of the platform. If the Riot ID cannot be
+// resolved again after it has been cached, the cached result is used.
+func (c *riotIDCache) resolve(platform, riotID,
+	apiKey string) (resolvedPlayer, error) {
+	cached, found := c.lookup(platform, riotID, apiKey)
+	if found && time.Since(cached.ResolvedAt) < riotIDRefreshInterval {
+		return cached, nil
+	}
+
+	resolved, err := resolveRiotID(platform, riotID, apiKey)
+	if err == errNotFound && found {
+		// The player may have changed their Riot ID, but their PUUID
+		// stays the same, so they can still be tracked.
+		if account, err := fetchAccountByPUUID(riotRoutingURL(platform, ""),
+			apiKey, cached.PUUID); err == nil {
+			log.Println("Riot ID " + riotID + " has changed to " +
+				account.GameName + "#" + account.TagLine +
+				", please update the configuration")
+		}
+
+		return cached, nil
+	} else if err != nil {
+		if found {
+			log.Println("failed to resolve Riot ID "+riotID+
+				", using cached result:", err)
+			return cached, nil
+		}
+
+		return resolvedPlayer{}, err
+	}
+
+	if found && resolved.PUUID != cached.PUUID {
+		log.Println("Riot ID " + riotID + " now belongs to another player")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.players[riotIDKey(apiKey, platform, riotID)] = resolved
+	if err := c.save(apiKey); err != nil {
+		log.Println("failed to save Riot ID cache:", err)
+	}
+
+	return resolved, nil
+}
+
+// lookupSummoner returns the PUUID of a player by their summoner ID
+// resolved with apiKey from the cache, without resolving it.
+func (c *riotIDCache) lookupSummoner(platform, summonerID,
+	apiKey string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	player, found := c.players[summonerKey(apiKey, platform, summonerID)]
+	return player.PUUID, found
+}
+
+// resolveSummoner returns the PUUID of a player from their summoner ID.
+// Summoner IDs always belong to the same player, so they are only resolved
+// once for each API key.
+func (c *riotIDCache) resolveSummoner(platform, summonerID,
+	apiKey string) (string, error) {
+	if puuid, found := c.lookupSummoner(platform, summonerID,
+		apiKey); found {
+		return puuid, nil
+	}
+
+	summoner, err := fetchSummonerByID(riotAPIURL(platform, ""), apiKey,
+		summonerID)
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.players[summonerKey(apiKey, platform, summonerID)] = resolvedPlayer{
+		PUUID:      summoner.PUUID,
+		SummonerID: summonerID,
+		ResolvedAt: time.Now(),
+	}
+	if err := c.save(apiKey); err != nil {
+		log.Println("failed to save Riot ID cache:", err)
+	}
+
+	return summoner.PUUID, nil
+}
+
+// save writes the cache to disk, dropping the players resolved with API
+// keys other than apiKey. The mutex must be locked before save is called.
+func (c *riotIDCache) save(apiKey string) error {
+	prefix := apiKeyHash(apiKey) + "/"
+	for key := range c.players {
+		if !strings.HasPrefix(key, prefix) {
+			delete(c.players, key)
+		}
+	}
+
+	data, err := json.Marshal(c.players)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(c.location+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(c.location+".tmp", c.location)
+}
+
+// resolveRiotID resolves a Riot ID through the Riot API.
+func resolveRiotID(platform, riotID, apiKey string) (resolvedPlayer, error) {
+	gameName, tagLine, ok := splitRiotID(riotID)
+	if !ok {
+		return resolvedPlayer{}, errors.New("invalid Riot ID: " + riotID)
+	}
+
+	account, err := fetchAccountByRiotID(riotRoutingURL(platform, ""), apiKey,
+		gameName, tagLine)
+	if err != nil {
+		return resolvedPlayer{}, err
+	}
+
+	summoner, err := fetchSummonerByPUUID(riotAPIURL(platform, ""), apiKey,
+		account.PUUID)
+	if err != nil {
+		return resolvedPlayer{}, err
+	}
+
+	return resolvedPlayer{
+		PUUID:      account.PUUID,
+		SummonerID: summoner.ID,
+		ResolvedAt: time.Now(),
+	}, nil
+}
+
+// riotRoutingURL returns the URL of a path on the regional routing Riot API
+// for a platform.
+func riotRoutingURL(platform, path string) string {
+	info, _ := record.LookupPlatform(platform)
+	return info.RoutingURL(path)
+}
+
+// fetchAccountByRiotID retrieves an account by its Riot ID from the
+// account API at baseURL.
+func fetchAccountByRiotID(baseURL, apiKey, gameName,
+	tagLine string) (riotAccount, error) {
+	var account riotAccount
+	err := getRiotAPI("account-v1/by-riot-id",
+		baseURL+"/riot/account/v1/accounts/by-riot-id/"+
+			url.PathEscape(gameName)+"/"+url.PathEscape(tagLine)+
+			"?api_key="+apiKey, &account)
+	return account, err
+}
+
+// fetchAccountByPUUID retrieves an account by its PUUID from the account
+// API at baseURL.
+func fetchAccountByPUUID(baseURL, apiKey, puuid string) (riotAccount, error) {
+	var account riotAccount
+	err := getRiotAPI("account-v1/by-puuid",
+		baseURL+"/riot/account/v1/accounts/by-puuid/"+
+			url.PathEscape(puuid)+"?api_key="+apiKey, &account)
+	return account, err
+}
+
+// fetchSummonerByPUUID retrieves a summoner by its PUUID from the summoner
+// API at baseURL.
+func fetchSummonerByPUUID(baseURL, apiKey,
+	puuid string) (summonerInfo, error) {
+	var summoner summonerInfo
+	err := getRiotAPI("summoner-v4/by-puuid",
+		baseURL+"/lol/summoner/v4/summoners/by-puuid/"+
+			url.PathEscape(puuid)+"?api_key="+apiKey, &summoner)
+	return summoner, err
+}
+
+// fetchSummonerByID retrieves a summoner by its summoner ID from the
+// summoner API at baseURL.
+func fetchSummonerByID(baseURL, apiKey,
+	summonerID string) (summonerInfo, error) {
+	var summoner summonerInfo
+	err := getRiotAPI("summoner-v4/by-id",
+		baseURL+"/lol/summoner/v4/summoners/"+
+			url.PathEscape(summonerID)+"?api_key="+apiKey, &summoner)
+	return summoner, err
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeAccountAPI is a stand-in for the account and summoner APIs of the
+// Riot API, with a single player whose Riot ID can be changed.
+type fakeAccountAPI struct {
+	*fakeRiotAPI
+	riotID string
+}
+
+func newFakeAccountAPI(t *testing.T) *fakeAccountAPI {
+	api := &fakeAccountAPI{riotID: "Some%20Name/EUW"}
+	api.fakeRiotAPI = newFakeRiotAPI(t, func(w http.ResponseWriter,
+		r *http.Request) {
+		api.mutex.Lock()
+		defer api.mutex.Unlock()
+
+		switch r.URL.EscapedPath() {
+		case "/riot/account/v1/accounts/by-riot-id/" + api.riotID:
+			w.Write([]byte(`{"puuid":"puuid-1","gameName":"Some Name",` +
+				`"tagLine":"EUW"}`))
+		case "/riot/account/v1/accounts/by-puuid/puuid-1":
+			w.Write([]byte(`{"puuid":"puuid-1","gameName":"New Name",` +
+				`"tagLine":"EUW"}`))
+		case "/lol/summoner/v4/summoners/by-puuid/puuid-1",
+			"/lol/summoner/v4/summoners/summoner-1":
+			w.Write([]byte(`{"id":"summoner-1","puuid":"puuid-1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	return api
+}
+
+func (api *fakeAccountAPI) rename(riotID string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.riotID = riotID
+}
+
+func TestSplitRiotID(t *testing.T) {
+	if name, tag, ok := splitRiotID("Some Name#EUW"); !ok ||
+		name != "Some Name" || tag != "EUW" {
+		t.Error("failed to split Riot ID:", name, tag, ok)
+	}
+
+	if name, tag, ok := splitRiotID("A#B#C"); !ok || name != "A#B" ||
+		tag != "C" {
+		t.Error("failed to split Riot ID:", name, tag, ok)
+	}
+
+	for _, riotID := range []string{"Name", "#TAG", "Name#", ""} {
+		if _, _, ok := splitRiotID(riotID); ok {
+			t.Error("split invalid Riot ID", riotID)
+		}
+	}
+}
+
+func TestRiotIDCache(t *testing.T) {
+	api := newFakeAccountAPI(t)
+
+	dir, err := ioutil.TempDir("", "riotid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const byRiotID = "/riot/account/v1/accounts/by-riot-id/Some%20Name/EUW"
+	location := dir + "/" + riotIDCacheFile
+	cache := newRiotIDCache(location)
+
+	resolved, err := cache.resolve("TEST1", "Some Name#EUW", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved.PUUID != "puuid-1" || resolved.SummonerID != "summoner-1" {
+		t.Errorf("unexpected resolved player: %+v", resolved)
+	}
+
+	// Riot IDs are case insensitive, and resolved Riot IDs are reused.
+	if _, err := cache.resolve("TEST1", "some name#euw", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := api.count(byRiotID); n != 1 {
+		t.Error("Riot ID was resolved", n, "times")
+	}
+
+	// Resolved Riot IDs are saved to and loaded from disk.
+	reloaded := newRiotIDCache(location)
+	if player, found := reloaded.lookup("TEST1", "Some Name#EUW",
+		"key"); !found ||
+		player.PUUID != "puuid-1" || player.SummonerID != "summoner-1" {
+		t.Errorf("Riot ID was not reloaded: %+v", player)
+	}
+
+	if _, err := reloaded.resolve("TEST1", "Some Name#EUW",
+		"key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := api.count(byRiotID); n != 1 {
+		t.Error("reloaded Riot ID was resolved", n, "times")
+	}
+
+	// A player who has changed their Riot ID is still tracked by their
+	// PUUID once their cached Riot ID is refreshed.
+	api.rename("New%20Name/EUW")
+	reloaded.players[riotIDKey("key", "TEST1", "Some Name#EUW")] = resolvedPlayer{
+		PUUID:      "puuid-1",
+		SummonerID: "summoner-1",
+		ResolvedAt: time.Now().Add(-2 * riotIDRefreshInterval),
+	}
+
+	resolved, err = reloaded.resolve("TEST1", "Some Name#EUW", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved.PUUID != "puuid-1" || resolved.SummonerID != "summoner-1" {
+		t.Errorf("unexpected renamed player: %+v", resolved)
+	}
+
+	if n := api.count("/riot/account/v1/accounts/by-puuid/puuid-1"); n != 1 {
+		t.Error("renamed account was retrieved", n, "times")
+	}
+
+	if _, err := reloaded.resolve("TEST1", "Nobody#EUW",
+		"key"); err != errNotFound {
+		t.Error("expected not found, got:", err)
+	}
+}
+
+func TestRiotIDCacheSummoner(t *testing.T) {
+	api := newFakeAccountAPI(t)
+
+	dir, err := ioutil.TempDir("", "riotid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	location := dir + "/" + riotIDCacheFile
+	cache := newRiotIDCache(location)
+
+	for i := 0; i < 2; i++ {
+		puuid, err := cache.resolveSummoner("TEST1", "summoner-1", "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if puuid != "puuid-1" {
+			t.Error("unexpected PUUID:", puuid)
+		}
+	}
+
+	if n := api.count("/lol/summoner/v4/summoners/summoner-1"); n != 1 {
+		t.Error("summoner ID was resolved", n, "times")
+	}
+
+	if puuid, found := newRiotIDCache(location).lookupSummoner("TEST1",
+		"summoner-1", "key"); !found || puuid != "puuid-1" {
+		t.Error("summoner ID was not reloaded:", puuid)
+	}
+}
+
+// TestRiotIDCacheAPIKey checks that players are resolved again when the API
+// key changes, as PUUIDs and summoner IDs differ between API keys.
+func TestRiotIDCacheAPIKey(t *testing.T) {
+	api := newFakeAccountAPI(t)
+
+	dir, err := ioutil.TempDir("", "riotid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const byRiotID = "/riot/account/v1/accounts/by-riot-id/Some%20Name/EUW"
+	const bySummonerID = "/lol/summoner/v4/summoners/summoner-1"
+	location := dir + "/" + riotIDCacheFile
+	cache := newRiotIDCache(location)
+
+	for _, apiKey := range []string{"key", "key", "other"} {
+		if _, err := cache.resolve("TEST1", "Some Name#EUW",
+			apiKey); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := cache.resolveSummoner("TEST1", "summoner-1",
+			apiKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := api.count(byRiotID); n != 2 {
+		t.Error("Riot ID was resolved", n, "times")
+	}
+
+	if n := api.count(bySummonerID); n != 2 {
+		t.Error("summoner ID was resolved", n, "times")
+	}
+
+	// Players resolved with the old API key are dropped.
+	reloaded := newRiotIDCache(location)
+	if _, found := reloaded.lookup("TEST1", "Some Name#EUW", "key"); found {
+		t.Error("Riot ID resolved with old API key was kept")
+	}
+
+	if _, found := reloaded.lookupSummoner("TEST1", "summoner-1",
+		"key"); found {
+		t.Error("summoner ID resolved with old API key was kept")
+	}
+
+	if _, found := reloaded.lookup("TEST1", "Some Name#EUW",
+		"other"); !found {
+		t.Error("Riot ID resolved with new API key was not kept")
+	}
+
+	if _, found := reloaded.lookupSummoner("TEST1", "summoner-1",
+		"other"); !found {
+		t.Error("summoner ID resolved with new API key was not kept")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const filterDateFormat = "2006-01-02"
+
+// recordingSummary holds the details of a recording's game which
+// recordings are searched by, so that searches do not need to decode the
+// user metadata of every recording.
+type recordingSummary struct {
+	queueID   int
+	hasQueue  bool
+	champions []int
+	// names holds the lower case summoner names and Riot IDs of the
+	// participants, and ids their summoner IDs and PUUIDs.
+	names []string
+	ids   []string
+}
+
+// newRecordingSummary returns the summary of a recording of game.
+func newRecordingSummary(game gameInfoMetadata) *recordingSummary {
+	summary := &recordingSummary{
+		queueID:  game.GameQueueConfigID,
+		hasQueue: len(game.Participants) > 0,
+	}
+
+	for _, participant := range game.Participants {
+		summary.champions = append(summary.champions, participant.ChampionID)
+
+		for _, name := range []string{participant.SummonerName,
+			participant.RiotID} {
+			if name != "" {
+				summary.names = append(summary.names, strings.ToLower(name))
+			}
+		}
+
+		for _, id := range []string{participant.SummonerID,
+			participant.PUUID} {
+			if id != "" {
+				summary.ids = append(summary.ids, id)
+			}
+		}
+	}
+
+	return summary
+}
+
+// recordingFilter filters recordings. The fields hold the filter as it was
+// given, and are empty if they should not be filtered by.
+type recordingFilter struct {
+	Summoner string
+	Champion string
+	Queue    string
+	Platform string
+	From     string
+	To       string
+	Complete string
+	Status   string
+
+	champions []int
+	queueID   int
+	from      time.Time
+	to        time.Time
+}
+
+// parseFilterTime parses a time given as a date or an RFC 3339 time. If end
+// is true, dates are the end of the day.
+func parseFilterTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(filterDateFormat, value); err == nil {
+		if end {
+			return t.Add(time.Hour * 24), nil
+		}
+
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseRecordingFilter reads a recordingFilter from the summoner, champion,
+// queue, platform, from, to, complete and status query parameters.
+// Champions may be given by ID or name.
+func parseRecordingFilter(query url.Values) (recordingFilter, error) {
+	f := recordingFilter{
+		Summoner: strings.TrimSpace(query.Get("summoner")),
+		Champion: strings.TrimSpace(query.Get("champion")),
+		Queue:    strings.TrimSpace(query.Get("queue")),
+		Platform: strings.ToUpper(strings.TrimSpace(query.Get("platform"))),
+		From:     strings.TrimSpace(query.Get("from")),
+		To:       strings.TrimSpace(query.Get("to")),
+		Complete: strings.TrimSpace(query.Get("complete")),
+		Status:   strings.TrimSpace(query.Get("status")),
+	}
+
+	if f.Champion != "" {
+		if id, err := strconv.Atoi(f.Champion); err == nil {
+			f.champions = []int{id}
+		} else {
+			championsMutex.RLock()
+			for id, champion := range allChampions {
+				if strings.EqualFold(champion.Name, f.Champion) {
+					f.champions = append(f.champions, id)
+				}
+			}
+			championsMutex.RUnlock()
+
+			if len(f.champions) == 0 {
+				return f, errors.New("unknown champion " + f.Champion)
+			}
+		}
+	}
+
+	if f.Queue != "" {
+		var err error
+		f.queueID, err = strconv.Atoi(f.Queue)
+		if err != nil {
+			return f, errors.New("queue must be a queue ID")
+		}
+	}
+
+	if f.From != "" {
+		var err error
+		f.from, err = parseFilterTime(f.From, false)
+		if err != nil {
+			return f, errors.New("from must be a date or time")
+		}
+	}
+
+	if f.To != "" {
+		var err error
+		f.to, err = parseFilterTime(f.To, true)
+		if err != nil {
+			return f, errors.New("to must be a date or time")
+		}
+	}
+
+	if f.Complete != "" && f.Complete != "true" && f.Complete != "false" {
+		return f, errors.New("complete must be true or false")
+	}
+
+	// Pending recordings have not been written to yet, so they are not
+	// listed and cannot be filtered by.
+	switch f.Status {
+	case "", statusRecording, statusComplete, statusIncomplete:
+	default:
+		return f, errors.New("status must be one of recording, complete " +
+			"or incomplete")
+	}
+
+	return f, nil
+}
+
+// query returns the filter as a URL query string, without the leading "?".
+func (f recordingFilter) query() string {
+	values := make(url.Values)
+	for name, value := range map[string]string{
+		"summoner": f.Summoner,
+		"champion": f.Champion,
+		"queue":    f.Queue,
+		"platform": f.Platform,
+		"from":     f.From,
+		"to":       f.To,
+		"complete": f.Complete,
+		"status":   f.Status,
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+
+	return values.Encode()
+}
+
+// matches returns whether or not a recording matches the filter.
+// recordingsMutex must be RLocked before matches is called.
+func (f recordingFilter) matches(internalRec *internalRecording) bool {
+	if internalRec.location == "" {
+		return false
+	}
+
+	entry := internalRec.current()
+	info := entry.Info
+
+	if f.Platform != "" && info.Platform != f.Platform {
+		return false
+	}
+
+	if !f.from.IsZero() && info.RecordTime.Before(f.from) {
+		return false
+	}
+
+	if !f.to.IsZero() && !info.RecordTime.Before(f.to) {
+		return false
+	}
+
+	if f.Status != "" && recordingStatus(internalRec) != f.Status {
+		return false
+	}
+
+	if f.Complete != "" &&
+		entry.Complete != (f.Complete == "true") {
+		return false
+	}
+
+	if f.Summoner == "" && f.Champion == "" && f.Queue == "" {
+		return true
+	}
+
+	summary := internalRec.summary
+	if summary == nil {
+		return false
+	}
+
+	if f.Queue != "" && (!summary.hasQueue || summary.queueID != f.queueID) {
+		return false
+	}
+
+	if f.Champion != "" && !summary.hasChampion(f.champions) {
+		return false
+	}
+
+	if f.Summoner != "" && !summary.hasSummoner(f.Summoner) {
+		return false
+	}
+
+	return true
+}
+
+func (s *recordingSummary) hasChampion(champions []int) bool {
+	for _, champion := range s.champions {
+		if containsInt(champions, champion) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasSummoner returns whether or not a participant's summoner ID or PUUID
+// is summoner, or their summoner name or Riot ID contains summoner.
+func (s *recordingSummary) hasSummoner(summoner string) bool {
+	for _, id := range s.ids {
+		if id == summoner {
+			return true
+		}
+	}
+
+	summoner = strings.ToLower(summoner)
+	for _, name := range s.names {
+		if strings.Contains(name, summoner) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasParticipant returns whether or not player is the summoner ID or PUUID,
+// or the summoner name or Riot ID of a participant.
+func (s *recordingSummary) hasParticipant(player string) bool {
+	if containsString(s.ids, player) {
+		return true
+	}
+
+	return containsString(s.names, strings.ToLower(player))
+}
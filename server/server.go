@@ -1,30 +1,39 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"path"
 	"runtime/debug"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/1lann/lol-replay/record"
 	"github.com/1lann/lol-replay/recording"
 	"github.com/1lann/lol-replay/replay"
 )
 
+// internalRecording is a recording of the server. file and rec are nil
+// while the recording is closed, in which case it is described by its
+// index entry.
 type internalRecording struct {
 	location  string
 	file      *os.File
 	rec       *recording.Recording
 	temporary bool
 	recording bool
+	removed   bool
+	summary   *recordingSummary
+	entry     indexEntry
+	// lastUsed is the time in Unix nanoseconds the recording was last
+	// opened for playback, and must be accessed atomically.
+	lastUsed int64
 }
 
 type internalServer struct {
@@ -36,13 +45,17 @@ type byTime []*internalRecording
 func (d byTime) Len() int      { return len(d) }
 func (d byTime) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
 func (d byTime) Less(i, j int) bool {
-	return d[i].rec.RetrieveGameInfo().RecordTime.Before(d[j].rec.RetrieveGameInfo().RecordTime)
+	return d[i].entry.Info.RecordTime.Before(d[j].entry.Info.RecordTime)
 }
 
 var sortedRecordings []*internalRecording
 var recordings = make(map[string]*internalRecording)
 var recordingsMutex = new(sync.RWMutex)
 
+// deletedRecordings holds the keys of the recordings that have been deleted
+// since the server started. It is protected by recordingsMutex.
+var deletedRecordings = make(map[string]bool)
+
 func isNumber(str string) bool {
 	for _, letter := range str {
 		if letter < '0' || letter > '9' {
@@ -53,24 +66,66 @@ func isNumber(str string) bool {
 	return true
 }
 
-func retrieve(region, gameID string) *recording.Recording {
+// retrieve implements replay.Retriever for the recordings of the server.
+// Recordings which are not public require a token if tokens are enabled.
+func retrieve(ctx context.Context, region,
+	gameID string) (*recording.Recording, error) {
 	if !record.IsValidPlatform(region) || !isNumber(gameID) {
-		return nil
+		return nil, replay.ErrNotFound
 	}
 
 	recordingsMutex.RLock()
-	defer recordingsMutex.RUnlock()
-
 	internalRec, found := recordings[region+"_"+gameID]
+	deleted := deletedRecordings[region+"_"+gameID]
+	temporary := found && internalRec.temporary
+	recordingsMutex.RUnlock()
+
 	if !found {
-		return nil
+		if deleted {
+			return nil, replay.ErrDeleted
+		}
+
+		return nil, replay.ErrNotFound
+	}
+
+	if temporary {
+		return nil, replay.ErrPending
 	}
 
-	if internalRec.temporary {
-		return nil
+	if err := authorizePlayback(ctx, region+"_"+gameID); err != nil {
+		return nil, err
 	}
 
-	return internalRec.rec
+	rec, err := openRecording(internalRec)
+	if err != nil {
+		log.Println("failed to open recording "+region+"_"+gameID+":", err)
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// replayHost returns the spectator host for a recording, which is given to
+// spectator clients. Each host has its own playback session, so that the
+// recording always plays back from the beginning, or from start if it is a
+// start path prefix such as one from replay.StartAtMinutePath. The game is
+// included in the host so that the version of the recording can be
+// reported to the client before it requests the game. If tokens are
+// enabled, the host also includes a signed token for the recording.
+func replayHost(r *http.Request, info recording.GameInfo,
+	start string) string {
+	return strings.Split(r.Host, ":")[0] + ":" +
+		strconv.Itoa(getConfig().ShowReplayPortAs) +
+		replay.SessionPath(replay.NewSessionID()) + tokenPath(info) +
+		replay.GamePath(info.Platform, info.GameID) + start
+}
+
+// replayString returns the replay command for a recording, to be used with
+// LoL Spectator.
+func replayString(r *http.Request, info recording.GameInfo,
+	start string) string {
+	return "replay " + replayHost(r, info, start) + " " +
+		info.EncryptionKey + " " + info.GameID + " " + info.Platform
 }
 
 func (s *internalServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -85,11 +140,26 @@ func (s *internalServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	if strings.HasPrefix(r.URL.Path, replay.PathHeader) {
+	if replay.IsReplayPath(r.URL.Path) {
 		s.replayRouter.ServeHTTP(w, r)
 		return
 	}
 
+	if strings.HasPrefix(r.URL.Path, launchPath) {
+		serveLaunch(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, apiV1Path) {
+		serveAPIV1(w, r)
+		return
+	}
+
+	if r.URL.Path == "/api/visibility" {
+		serveVisibility(w, r)
+		return
+	}
+
 	if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api") {
 		w.Header().Set("Access-Control-Allow-Methods", "GET")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -136,23 +206,42 @@ func main() {
 		configLocation = os.Args[1]
 	}
 
-	readConfiguration(configLocation)
+	conf, err := readConfiguration(configLocation)
+	if err != nil {
+		log.Fatal("failed to read configuration: ", err)
+	}
+
+	if err := conf.usePlatforms(); err != nil {
+		log.Fatal("failed to register platforms: ", err)
+	}
+
+	setConfig(conf)
 
-	dir, err := ioutil.ReadDir(config.RecordingsDirectory)
+	dir, err := ioutil.ReadDir(conf.RecordingsDirectory)
 	if os.IsNotExist(err) {
-		os.Mkdir(config.RecordingsDirectory, 0755)
+		os.Mkdir(conf.RecordingsDirectory, 0755)
 	} else if err != nil {
 		log.Fatal(err)
 		return
-	} else {
-		loadRecordings(dir, config.RecordingsDirectory)
 	}
 
-	if len(config.Players) == 0 {
-		log.Fatal("you cannot start the server without any monitored players")
+	if err := openIndex(conf.RecordingsDirectory + "/" +
+		indexFile); err != nil {
+		log.Println("failed to read recording index, rebuilding it:", err)
 	}
 
-	internal := &internalServer{replay.Router(retrieve)}
+	loadRecordings(dir, conf.RecordingsDirectory)
+
+	loadVisibilities()
+	loadPins()
+	riotIDs = newRiotIDCache(conf.RecordingsDirectory + "/" + riotIDCacheFile)
+
+	router := replay.NewRouter(replay.RetrieverFunc(retrieve), replay.Options{
+		LiveDelay: time.Duration(conf.LiveDelaySeconds) * time.Second,
+		Proxy:     conf.Proxy,
+		TokenKey:  []byte(conf.TokenSecret),
+	})
+	internal := &internalServer{router}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -166,6 +255,10 @@ func main() {
 		// Lock recordings to safely close them
 		wg := new(sync.WaitGroup)
 		for _, internalRec := range recordings {
+			if internalRec.rec == nil {
+				continue
+			}
+
 			wg.Add(1)
 			go func(internalRec *internalRecording) {
 				internalRec.rec.Lock()
@@ -179,60 +272,12 @@ func main() {
 	}()
 
 	go maintainStaticData()
+	go closeIdleRecordings()
+	go enforceRetention()
+	go watchConfiguration(configLocation)
 	cleanUp()
 	go monitorPlayers()
+	go monitorFeatured()
 
-	log.Fatal(http.ListenAndServe(config.BindAddress, internal))
-}
-
-func loadRecordings(dir []os.FileInfo, dirName string) {
-	for _, fileInfo := range dir {
-		if fileInfo.IsDir() {
-			continue
-		}
-
-		filename := path.Base(fileInfo.Name())
-
-		if path.Ext(filename) != ".glr" {
-			continue
-		}
-
-		file, err := os.OpenFile(dirName+"/"+filename, os.O_RDWR, 0666)
-		if err != nil {
-			log.Println("failed to open "+filename+":", err)
-			continue
-		}
-
-		rec, err := recording.NewRecording(file)
-		if err != nil {
-			log.Println("failed to read recording "+filename+":", err)
-			file.Close()
-			continue
-		}
-
-		if !rec.HasGameMetadata() {
-			file.Close()
-			log.Println("deleting empty recording: " + filename)
-			if err := os.Remove(dirName + "/" + filename); err != nil {
-				log.Println("failed to delete empty recording:", err)
-			}
-			continue
-		}
-
-		internalRec := &internalRecording{
-			file:      file,
-			location:  dirName + "/" + filename,
-			rec:       rec,
-			temporary: false,
-			recording: false,
-		}
-
-		key := rec.RetrieveGameInfo().Platform + "_" +
-			rec.RetrieveGameInfo().GameID
-
-		recordings[key] = internalRec
-		sortedRecordings = append(sortedRecordings, internalRec)
-	}
-
-	sort.Sort(byTime(sortedRecordings))
+	log.Fatal(http.ListenAndServe(conf.BindAddress, internal))
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// The versions of the spectator API that are supported. Spectator-v4
+// identifies players by summoner ID, and spectator-v5 by PUUID.
+const (
+	spectatorV4 = 4
+	spectatorV5 = 5
+
+	defaultSpectatorVersion = spectatorV5
+)
+
+func isValidSpectatorVersion(version int) bool {
+	return version == spectatorV4 || version == spectatorV5
+}
+
+// spectatorV5Game is an active or featured game in a spectator-v5
+// response.
+type spectatorV5Game struct {
+	BannedChampions   []bannedChampion `json:"bannedChampions"`
+	GameID            int64            `json:"gameId"`
+	GameLength        int              `json:"gameLength"`
+	GameMode          string           `json:"gameMode"`
+	GameQueueConfigID int              `json:"gameQueueConfigId"`
+	GameStartTime     int64            `json:"gameStartTime"`
+	GameType          string           `json:"gameType"`
+	MapID             int              `json:"mapId"`
+	Observers         struct {
+		EncryptionKey string `json:"encryptionKey"`
+	} `json:"observers"`
+	Participants []struct {
+		Bot           bool   `json:"bot"`
+		ChampionID    int    `json:"championId"`
+		ProfileIconID int    `json:"profileIconId"`
+		PUUID         string `json:"puuid"`
+		RiotID        string `json:"riotId"`
+		Spell1Id      int    `json:"spell1Id"`
+		Spell2Id      int    `json:"spell2Id"`
+		SummonerID    string `json:"summonerId"`
+		TeamID        int    `json:"teamId"`
+	} `json:"participants"`
+	PlatformID string `json:"platformId"`
+}
+
+// gameInfo maps a spectator-v5 game into a gameInfoMetadata. The Riot ID
+// of each participant is also used as their summoner name, as summoner
+// names are no longer returned.
+func (g spectatorV5Game) gameInfo() gameInfoMetadata {
+	info := gameInfoMetadata{
+		BannedChampions:   g.BannedChampions,
+		GameID:            g.GameID,
+		GameLength:        g.GameLength,
+		GameMode:          g.GameMode,
+		GameQueueConfigID: g.GameQueueConfigID,
+		GameStartTime:     g.GameStartTime,
+		GameType:          g.GameType,
+		MapID:             g.MapID,
+		PlatformID:        g.PlatformID,
+	}
+
+	info.Observers.EncryptionKey = g.Observers.EncryptionKey
+
+	for _, p := range g.Participants {
+		info.Participants = append(info.Participants, gameParticipant{
+			Bot:           p.Bot,
+			ChampionID:    p.ChampionID,
+			ProfileIconID: p.ProfileIconID,
+			Spell1Id:      p.Spell1Id,
+			Spell2Id:      p.Spell2Id,
+			SummonerID:    p.SummonerID,
+			SummonerName:  p.RiotID,
+			PUUID:         p.PUUID,
+			RiotID:        p.RiotID,
+			TeamID:        p.TeamID,
+		})
+	}
+
+	return info
+}
+
+// spectatorClient retrieves games from a version of the spectator API of a
+// platform, and maps them into gameInfoMetadata.
+type spectatorClient struct {
+	baseURL string
+	apiKey  string
+	version int
+}
+
+// newSpectatorClient returns a spectatorClient for a platform which uses
+// the configured version of the spectator API.
+func newSpectatorClient(platform, apiKey string) spectatorClient {
+	version := getConfig().SpectatorVersion
+	if version == 0 {
+		version = defaultSpectatorVersion
+	}
+
+	return spectatorClient{
+		baseURL: riotAPIURL(platform, ""),
+		apiKey:  apiKey,
+		version: version,
+	}
+}
+
+// method returns the name of a spectator API method, for rate limiting.
+func (c spectatorClient) method(endpoint string) string {
+	return "spectator-v" + strconv.Itoa(c.version) + endpoint
+}
+
+func (c spectatorClient) path(endpoint string) string {
+	return c.baseURL + "/lol/spectator/v" + strconv.Itoa(c.version) +
+		endpoint + "?api_key=" + c.apiKey
+}
+
+// activeGame retrieves the game a player is currently in, where the player
+// is identified by summoner ID for spectator-v4, and PUUID for
+// spectator-v5. errNotFound is returned if the player is not in a game.
+func (c spectatorClient) activeGame(player string) (gameInfoMetadata, error) {
+	if c.version == spectatorV4 {
+		var info gameInfoMetadata
+		err := getRiotAPI(c.method("/active-games"),
+			c.path("/active-games/by-summoner/"+
+				url.PathEscape(player)), &info)
+		return info, err
+	}
+
+	var game spectatorV5Game
+	if err := getRiotAPI(c.method("/active-games"),
+		c.path("/active-games/by-summoner/"+
+			url.PathEscape(player)), &game); err != nil {
+		return gameInfoMetadata{}, err
+	}
+
+	return game.gameInfo(), nil
+}
+
+// featuredGames retrieves the list of featured games.
+func (c spectatorClient) featuredGames() ([]gameInfoMetadata, error) {
+	if c.version == spectatorV4 {
+		var featured struct {
+			GameList []gameInfoMetadata `json:"gameList"`
+		}
+		err := getRiotAPI(c.method("/featured-games"),
+			c.path("/featured-games"), &featured)
+		return featured.GameList, err
+	}
+
+	var featured struct {
+		GameList []spectatorV5Game `json:"gameList"`
+	}
+	if err := getRiotAPI(c.method("/featured-games"),
+		c.path("/featured-games"), &featured); err != nil {
+		return nil, err
+	}
+
+	games := make([]gameInfoMetadata, 0, len(featured.GameList))
+	for _, game := range featured.GameList {
+		games = append(games, game.gameInfo())
+	}
+
+	return games, nil
+}
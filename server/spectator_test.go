@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// newFakeSpectatorAPI returns a stand-in for the spectator API of the Riot
+// API which serves the fixtures in testdata.
+func newFakeSpectatorAPI(t *testing.T) *httptest.Server {
+	fixtures := map[string]string{
+		"/lol/spectator/v4/active-games/by-summoner/summoner-1": "spectator-v4-active-game.json",
+		"/lol/spectator/v5/active-games/by-summoner/puuid-1":    "spectator-v5-active-game.json",
+		"/lol/spectator/v4/featured-games":                      "spectator-v4-featured-games.json",
+		"/lol/spectator/v5/featured-games":                      "spectator-v5-featured-games.json",
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Query().Get("api_key") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		name, found := fixtures[r.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(readFixture(t, name))
+	}))
+}
+
+func checkV5Game(t *testing.T, info gameInfoMetadata) {
+	if info.GameID != 5678901234 || info.PlatformID != "EUW1" ||
+		info.GameMode != "CLASSIC" || info.GameQueueConfigID != 420 ||
+		info.MapID != 11 || info.GameLength != 95 ||
+		info.GameStartTime != 1700000000000 {
+		t.Errorf("unexpected game: %+v", info)
+	}
+
+	if info.Observers.EncryptionKey != "1d4TkGhzTpl0/S3gmQ3RMd7eMNXsOlqN" {
+		t.Error("unexpected encryption key:", info.Observers.EncryptionKey)
+	}
+
+	if len(info.BannedChampions) != 2 ||
+		info.BannedChampions[1].ChampionID != 238 ||
+		info.BannedChampions[1].TeamID != 200 {
+		t.Errorf("unexpected bans: %+v", info.BannedChampions)
+	}
+
+	if len(info.Participants) != 2 {
+		t.Fatal("game has", len(info.Participants), "participants")
+	}
+
+	p := info.Participants[0]
+	if p.PUUID != "puuid-1" || p.RiotID != "Some Name#EUW" ||
+		p.SummonerName != "Some Name#EUW" || p.SummonerID != "summoner-1" ||
+		p.ChampionID != 103 || p.TeamID != 100 || p.Spell1Id != 4 ||
+		p.Spell2Id != 14 || p.ProfileIconID != 4568 {
+		t.Errorf("unexpected participant: %+v", p)
+	}
+
+	if p := info.Participants[1]; p.SummonerID != "" ||
+		p.SummonerName != "Other#TAG" {
+		t.Errorf("unexpected participant without summoner ID: %+v", p)
+	}
+}
+
+func TestSpectatorV5GameInfo(t *testing.T) {
+	var game spectatorV5Game
+	if err := json.Unmarshal(readFixture(t,
+		"spectator-v5-active-game.json"), &game); err != nil {
+		t.Fatal(err)
+	}
+
+	checkV5Game(t, game.gameInfo())
+}
+
+func TestSpectatorV4Decode(t *testing.T) {
+	var info gameInfoMetadata
+	if err := json.Unmarshal(readFixture(t,
+		"spectator-v4-active-game.json"), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.GameID != 4567890123 || info.GameQueueConfigID != 420 ||
+		info.Observers.EncryptionKey != "q7xBnuO0SeOkJ+oZtvUhAt6hTfDVw2Kb" ||
+		len(info.BannedChampions) != 2 || len(info.Participants) != 2 {
+		t.Fatalf("unexpected game: %+v", info)
+	}
+
+	if p := info.Participants[0]; p.SummonerID != "summoner-1" ||
+		p.SummonerName != "Old Name" || p.ChampionID != 103 ||
+		p.PUUID != "" || p.RiotID != "" {
+		t.Errorf("unexpected participant: %+v", p)
+	}
+}
+
+func TestSpectatorClient(t *testing.T) {
+	server := newFakeSpectatorAPI(t)
+	defer server.Close()
+
+	v5 := spectatorClient{baseURL: server.URL, apiKey: "key",
+		version: spectatorV5}
+	info, err := v5.activeGame("puuid-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkV5Game(t, info)
+
+	if _, err := v5.activeGame("puuid-2"); err != errNotFound {
+		t.Error("expected not found, got:", err)
+	}
+
+	games, err := v5.featuredGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(games) != 1 || games[0].GameID != 5678901235 ||
+		games[0].Participants[0].SummonerName != "Featured#KR1" {
+		t.Errorf("unexpected featured games: %+v", games)
+	}
+
+	v4 := spectatorClient{baseURL: server.URL, apiKey: "key",
+		version: spectatorV4}
+	info, err = v4.activeGame("summoner-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.GameID != 4567890123 ||
+		info.Participants[0].SummonerName != "Old Name" {
+		t.Errorf("unexpected game: %+v", info)
+	}
+
+	games, err = v4.featuredGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(games) != 1 || games[0].GameID != 4567890124 ||
+		games[0].Participants[0].SummonerName != "Featured Player" {
+		t.Errorf("unexpected featured games: %+v", games)
+	}
+}
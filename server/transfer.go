@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/1lann/lol-replay/record"
+	"github.com/1lann/lol-replay/recording"
+)
+
+// maxUploadBytes is the largest recording file which can be uploaded.
+const maxUploadBytes = 256 << 20
+
+// downloadPath returns the path of the API endpoint which downloads the
+// file of a recording.
+func downloadPath(info recording.GameInfo) string {
+	return apiV1Path + "recordings/" + info.Platform + "/" + info.GameID +
+		"/file"
+}
+
+// serveAPIV1Download serves the .glr file of a recording to admins.
+// Recordings in progress cannot be downloaded, as their files are still
+// being written to.
+func serveAPIV1Download(w http.ResponseWriter, r *http.Request,
+	keyName string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	recordingsMutex.RLock()
+	internalRec, found := recordings[keyName]
+	var location string
+	var entry indexEntry
+	inProgress := false
+	if found {
+		location = internalRec.location
+		entry = internalRec.current()
+		inProgress = internalRec.temporary || internalRec.recording
+	}
+	recordingsMutex.RUnlock()
+
+	if !found {
+		writeAPIV1Error(w, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	if inProgress {
+		writeAPIV1Error(w, http.StatusConflict, "recording is in progress")
+		return
+	}
+
+	file, err := os.Open(location)
+	if err != nil {
+		log.Println("failed to open "+location+" for download:", err)
+		writeAPIV1Error(w, http.StatusInternalServerError,
+			"internal server error")
+		return
+	}
+
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+
+		keyName+".glr\"")
+	http.ServeContent(w, r, keyName+".glr", entry.LastWriteTime, file)
+}
+
+// serveAPIV1Upload adds a recording from a .glr file uploaded by an admin
+// as the body of the request. The recording is stored as
+// <platform>_<game ID>.glr, and must not already exist. The retention policy
+// is applied once the recording is added, in the same way as when a
+// recording starts, which may remove the uploaded recording itself.
+func serveAPIV1Upload(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if r.ContentLength > maxUploadBytes {
+		writeAPIV1Error(w, http.StatusRequestEntityTooLarge,
+			"recording is too large")
+		return
+	}
+
+	dir := getConfig().RecordingsDirectory
+	file, err := ioutil.TempFile(dir, "upload-")
+	if err != nil {
+		log.Println("failed to create upload file:", err)
+		writeAPIV1Error(w, http.StatusInternalServerError,
+			"internal server error")
+		return
+	}
+
+	tmpLocation := file.Name()
+	defer os.Remove(tmpLocation)
+
+	_, err = io.Copy(file, http.MaxBytesReader(w, r.Body, maxUploadBytes))
+	if err != nil {
+		file.Close()
+		writeAPIV1Error(w, http.StatusBadRequest, "failed to read upload: "+
+			err.Error())
+		return
+	}
+
+	info, err := validateUpload(file)
+	file.Close()
+	if err != nil {
+		writeAPIV1Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	keyName := info.Platform + "_" + info.GameID
+	filename := keyName + ".glr"
+
+	recordingsMutex.Lock()
+	defer recordingsMutex.Unlock()
+
+	if _, found := recordings[keyName]; found {
+		writeAPIV1Error(w, http.StatusConflict,
+			"recording "+keyName+" already exists")
+		return
+	}
+
+	if _, err := os.Stat(dir + "/" + filename); err == nil {
+		writeAPIV1Error(w, http.StatusConflict,
+			"recording "+keyName+" already exists")
+		return
+	}
+
+	if err := os.Rename(tmpLocation, dir+"/"+filename); err != nil {
+		log.Println("failed to store uploaded recording:", err)
+		writeAPIV1Error(w, http.StatusInternalServerError,
+			"internal server error")
+		return
+	}
+
+	_, entry, ok := indexRecording(dir, filename)
+	if !ok {
+		os.Remove(dir + "/" + filename)
+		writeAPIV1Error(w, http.StatusInternalServerError,
+			"internal server error")
+		return
+	}
+
+	putIndexEntry(keyName, entry)
+	addRecording(keyName, dir+"/"+filename, entry)
+	sort.Sort(byTime(sortedRecordings))
+	delete(deletedRecordings, keyName)
+
+	log.Println("uploaded: " + filename)
+
+	internalRec := recordings[keyName]
+	cleanUp()
+	if internalRec.removed {
+		writeAPIV1Error(w, http.StatusConflict, "recording "+keyName+
+			" was removed by the retention policy")
+		return
+	}
+
+	w.Header().Set("Location", apiV1Path+"recordings/"+info.Platform+"/"+
+		info.GameID)
+	writeAPIV1(w, http.StatusCreated,
+		newAPIV1Recording(r, keyName, internalRec))
+}
+
+// validateUpload reads the uploaded recording in file, and returns the
+// information of its game.
+func validateUpload(file *os.File) (recording.GameInfo, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return recording.GameInfo{}, err
+	}
+
+	rec, err := recording.NewRecording(file)
+	if err != nil {
+		return recording.GameInfo{}, errors.New("invalid recording: " +
+			err.Error())
+	}
+
+	if !rec.HasGameMetadata() {
+		return recording.GameInfo{}, errors.New("recording is empty")
+	}
+
+	info := rec.RetrieveGameInfo()
+	if !record.IsValidPlatform(info.Platform) || info.GameID == "" ||
+		!isNumber(info.GameID) {
+		return recording.GameInfo{}, errors.New("recording has an " +
+			"invalid platform or game ID")
+	}
+
+	return info, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/1lann/lol-replay/record"
+)
+
+// apiV1Record is the body of a request to record a game, which is either
+// identified by its game ID and encryption key, or looked up as the game a
+// summoner is currently in by their Riot ID or summoner ID. The encryption
+// key is required with a game ID, as a recording cannot be played without
+// it.
+type apiV1Record struct {
+	Platform      string `json:"platform"`
+	GameID        string `json:"game_id"`
+	EncryptionKey string `json:"encryption_key"`
+	RiotID        string `json:"riot_id"`
+	SummonerID    string `json:"summoner_id"`
+}
+
+// serveAPIV1Record starts recording a game requested by an admin, in the
+// same way as the games of monitored players are recorded. The recording is
+// returned with the pending status if it was started, and a conflict is
+// returned if the game is already being recorded or has been recorded.
+func serveAPIV1Record(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req apiV1Record
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeAPIV1Error(w, http.StatusBadRequest, "invalid body: "+
+			err.Error())
+		return
+	}
+
+	req.Platform = strings.ToUpper(strings.TrimSpace(req.Platform))
+	req.GameID = strings.TrimSpace(req.GameID)
+	req.EncryptionKey = strings.TrimSpace(req.EncryptionKey)
+	req.RiotID = strings.TrimSpace(req.RiotID)
+	req.SummonerID = strings.TrimSpace(req.SummonerID)
+
+	if !record.IsValidPlatform(req.Platform) {
+		writeAPIV1Error(w, http.StatusBadRequest, "platform must be a "+
+			"valid platform ID")
+		return
+	}
+
+	var info gameInfoMetadata
+	switch {
+	case req.GameID != "":
+		id, err := strconv.ParseInt(req.GameID, 10, 64)
+		if err != nil || id <= 0 {
+			writeAPIV1Error(w, http.StatusBadRequest,
+				"game_id must be a game ID")
+			return
+		}
+
+		if req.EncryptionKey == "" || !isSafeKey(req.EncryptionKey) {
+			writeAPIV1Error(w, http.StatusBadRequest, "encryption_key "+
+				"must be the encryption key of the game")
+			return
+		}
+
+		info.GameID = id
+		info.PlatformID = req.Platform
+		info.Observers.EncryptionKey = req.EncryptionKey
+	case req.RiotID != "" || req.SummonerID != "":
+		if req.RiotID != "" {
+			if _, _, ok := splitRiotID(req.RiotID); !ok {
+				writeAPIV1Error(w, http.StatusBadRequest,
+					"riot_id must be of the form Name#TAG")
+				return
+			}
+		}
+
+		var status int
+		var err error
+		info, status, err = lookupActiveGame(configPlayer{
+			ID:       req.SummonerID,
+			RiotID:   req.RiotID,
+			Platform: req.Platform,
+		})
+		if err != nil {
+			writeAPIV1Error(w, status, err.Error())
+			return
+		}
+
+		if info.PlatformID == "" {
+			info.PlatformID = req.Platform
+		}
+	default:
+		writeAPIV1Error(w, http.StatusBadRequest, "one of game_id, "+
+			"riot_id or summoner_id is required")
+		return
+	}
+
+	keyName := info.PlatformID + "_" + strconv.FormatInt(info.GameID, 10)
+
+	if startRecording(info) {
+		log.Println("recording requested game: " + keyName)
+		writeAPIV1(w, http.StatusAccepted, newPendingAPIV1Recording(keyName))
+		return
+	}
+
+	recordingsMutex.RLock()
+	internalRec, found := recordings[keyName]
+	status := statusPending
+	if found {
+		status = recordingStatus(internalRec)
+	}
+	recordingsMutex.RUnlock()
+
+	if status == statusComplete {
+		writeAPIV1Error(w, http.StatusConflict, "game "+keyName+
+			" has already been recorded")
+		return
+	}
+
+	writeAPIV1Error(w, http.StatusConflict, "game "+keyName+
+		" is already being recorded")
+}
+
+// lookupActiveGame retrieves the game a player is currently in, and
+// returns the status of the response to give if the game cannot be found.
+func lookupActiveGame(player configPlayer) (gameInfoMetadata, int, error) {
+	apiKey := getConfig().RiotAPIKey
+	client := newSpectatorClient(player.Platform, apiKey)
+
+	id, err := player.spectatorID(client.version, apiKey)
+	if err == errNotFound {
+		return gameInfoMetadata{}, http.StatusNotFound,
+			errors.New("summoner " + player.name() + " not found")
+	} else if err != nil {
+		log.Println("failed to resolve "+player.name()+":", err)
+		return gameInfoMetadata{}, http.StatusBadGateway,
+			errors.New("failed to look up summoner " + player.name())
+	}
+
+	info, err := client.activeGame(id)
+	if err == errNotFound {
+		return gameInfoMetadata{}, http.StatusNotFound,
+			errors.New(player.name() + " is not in a game")
+	} else if err != nil {
+		log.Println("current game error:", err)
+		return gameInfoMetadata{}, http.StatusBadGateway,
+			errors.New("failed to look up the game of " + player.name())
+	}
+
+	return info, http.StatusOK, nil
+}
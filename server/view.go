@@ -6,11 +6,14 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/1lann/lol-replay/record"
 	"github.com/1lann/lol-replay/recording"
+	"github.com/1lann/lol-replay/replay"
 	"github.com/dustin/go-humanize"
 )
 
@@ -20,6 +23,11 @@ type playerArg struct {
 	Summoner      string
 }
 
+type startCodeArg struct {
+	Label string
+	Code  string
+}
+
 type recordingArg struct {
 	IsComplete       bool
 	NoMetadata       bool
@@ -32,6 +40,28 @@ type recordingArg struct {
 	Region           string
 	AQueue           string
 	Code             string
+	StartCodes       []startCodeArg
+	Live             bool
+	LiveDelay        string
+	Visibility       string
+	ShareLink        string
+	Pinned           bool
+	Removal          string
+	DownloadLink     string
+	Key              string
+	LaunchLink       template.URL
+	WindowsScript    string
+	MacScript        string
+}
+
+type removalArg struct {
+	Key    string
+	Reason string
+}
+
+type queueOption struct {
+	ID   string
+	Name string
 }
 
 type renderArg struct {
@@ -41,8 +71,22 @@ type renderArg struct {
 	NextPage     int
 	PreviousPage int
 	LoadTime     string
+	Filter       recordingFilter
+	FilterError  string
+	Query        string
+	Queues       []queueOption
+	Platforms    []string
+	Champions    []string
+	IsAdmin      bool
+	CSRFToken    string
+	Removals     []removalArg
+	RemovalSize  string
 }
 
+// startCodeInterval is the number of minutes between the start times
+// offered to watch a recording from.
+const startCodeInterval = 5
+
 var pageTemplate *template.Template
 
 func serveView(w http.ResponseWriter, r *http.Request) {
@@ -61,19 +105,30 @@ func serveView(w http.ResponseWriter, r *http.Request) {
 		currentPage = num
 	}
 
-	recordingsMutex.RLock()
-	numRecordings := len(sortedRecordings)
-	recordingsMutex.RUnlock()
+	// Admins log in with the key query parameter, which is removed from the
+	// address straight away so that it is not kept in the browser's history
+	// or sent in Referer headers.
+	if query := r.URL.Query(); query.Get("key") != "" {
+		loginAdmin(w, r)
+		query.Del("key")
 
-	if (currentPage > int(math.Ceil(float64(numRecordings)/
-		float64(config.ShowPerPage))) && currentPage != 1) ||
-		currentPage < 1 {
-		http.Redirect(w, r, "/", http.StatusFound)
+		target := r.URL.Path
+		if encoded := query.Encode(); encoded != "" {
+			target += "?" + encoded
+		}
+
+		http.Redirect(w, r, target, http.StatusSeeOther)
 		return
 	}
 
 	templateRenderArg := getRenderArg(r, currentPage)
 
+	if (currentPage > len(templateRenderArg.Pages) && currentPage != 1) ||
+		currentPage < 1 {
+		http.Redirect(w, r, "/"+templateRenderArg.Query, http.StatusFound)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
@@ -95,18 +150,24 @@ func serveView(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getPlayerArg(summonerID string, summonerName string, championID int,
+func getPlayerArg(player gameParticipant,
 	info recording.GameInfo) (playerArg, bool) {
 	var thisPlayer playerArg
+	conf := getConfig()
 
 	// Find player in monitor list
 	match := false
-	for _, monitoredPlayer := range config.Players {
+	for _, monitoredPlayer := range conf.Players {
 		if monitoredPlayer.Platform != info.Platform {
 			continue
 		}
 
-		if monitoredPlayer.ID != summonerID {
+		// Games recorded from spectator-v5 may only identify players by
+		// PUUID.
+		if (player.SummonerID == "" ||
+			monitoredPlayer.cachedSummonerID() != player.SummonerID) &&
+			(player.PUUID == "" ||
+				monitoredPlayer.cachedPUUID() != player.PUUID) {
 			continue
 		}
 
@@ -118,9 +179,9 @@ func getPlayerArg(summonerID string, summonerName string, championID int,
 		return playerArg{}, false
 	}
 
-	thisPlayer.Summoner = summonerName
+	thisPlayer.Summoner = player.SummonerName
 
-	if champion, found := allChampions[championID]; found {
+	if champion, found := allChampions[player.ChampionID]; found {
 		thisPlayer.ChampionName = champion.Name
 		thisPlayer.ChampionImage =
 			"https://ddragon.leagueoflegends.com/cdn/" +
@@ -128,7 +189,7 @@ func getPlayerArg(summonerID string, summonerName string, championID int,
 	} else {
 		thisPlayer.ChampionName = "an unknown champion"
 		thisPlayer.ChampionImage = ""
-		log.Println("render: missing champion:", championID)
+		log.Println("render: missing champion:", player.ChampionID)
 	}
 
 	return thisPlayer, true
@@ -136,57 +197,140 @@ func getPlayerArg(summonerID string, summonerName string, championID int,
 
 func getRenderArg(r *http.Request, currentPage int) renderArg {
 	start := time.Now()
+	conf := getConfig()
+	liveDelaySeconds := conf.LiveDelaySeconds
 
-	recordingsMutex.RLock()
-	defer recordingsMutex.RUnlock()
-
-	numPages := int(math.Ceil(float64(len(sortedRecordings)) /
-		float64(config.ShowPerPage)))
-
+	filter, err := parseRecordingFilter(r.URL.Query())
 	renderTemplateArg := renderArg{
 		CurrentPage:  currentPage,
-		Pages:        makePages(numPages),
 		NextPage:     currentPage + 1,
 		PreviousPage: currentPage - 1,
-		Recordings:   make([]recordingArg, 0, config.ShowPerPage),
+		Recordings:   make([]recordingArg, 0, conf.ShowPerPage),
+		Filter:       filter,
+		Queues:       queueOptions(),
+		Platforms:    platformIDs(),
+		Champions:    championNames(),
 	}
 
-	for i := len(sortedRecordings) - ((currentPage - 1) *
-		config.ShowPerPage) - 1; i >= 0 && i > len(sortedRecordings)-
-		(currentPage*config.ShowPerPage)-1; i-- {
+	if query := filter.query(); query != "" {
+		renderTemplateArg.Query = "?" + query
+	}
 
-		var recRenderArg recordingArg
-		rec := sortedRecordings[i]
+	if err != nil {
+		renderTemplateArg.FilterError = capitalize(err.Error())
+		renderTemplateArg.Pages = makePages(0)
+		return renderTemplateArg
+	}
+
+	recordingsMutex.RLock()
+	defer recordingsMutex.RUnlock()
+
+	// Admins are shown what the next cleanup by the retention policy would
+	// remove.
+	removals := make(map[string]string)
+	if isAdmin(r) {
+		renderTemplateArg.IsAdmin = true
+		renderTemplateArg.CSRFToken = csrfToken(r)
+
+		var removalSize int64
+		for _, removal := range retentionPlan(conf, time.Now()) {
+			renderTemplateArg.Removals = append(renderTemplateArg.Removals,
+				removalArg{Key: removal.key, Reason: removal.reason})
+			removals[removal.key] = removal.reason
+			removalSize += removal.entry.FileSize
+		}
+
+		renderTemplateArg.RemovalSize = humanize.Bytes(uint64(removalSize))
+	}
 
+	// A link to a single recording shows only that recording.
+	linked := r.URL.Query().Get("game")
+
+	var selected []*internalRecording
+	for i := len(sortedRecordings) - 1; i >= 0; i-- {
+		rec := sortedRecordings[i]
 		if rec.temporary {
 			continue
 		}
 
+		info := rec.current().Info
+		keyName := info.Platform + "_" + info.GameID
+		if (linked != "" && keyName != linked) || !canList(r, keyName) ||
+			!filter.matches(rec) {
+			continue
+		}
+
+		selected = append(selected, rec)
+	}
+
+	numPages := int(math.Ceil(float64(len(selected)) /
+		float64(conf.ShowPerPage)))
+	renderTemplateArg.Pages = makePages(numPages)
+
+	first := (currentPage - 1) * conf.ShowPerPage
+	if first < 0 || first > len(selected) {
+		first = len(selected)
+	}
+
+	last := first + conf.ShowPerPage
+	if last > len(selected) {
+		last = len(selected)
+	}
+
+	for _, rec := range selected[first:last] {
+		var recRenderArg recordingArg
+
+		entry := rec.current()
+		info := entry.Info
+		keyName := info.Platform + "_" + info.GameID
+
+		recRenderArg.Key = keyName
+		if visibility := recordingVisibility(keyName); visibility !=
+			visibilityPublic {
+			recRenderArg.Visibility = capitalize(visibility)
+			recRenderArg.ShareLink = shareLink(keyName)
+		}
+		recRenderArg.Pinned = isPinned(keyName)
+		recRenderArg.Removal = removals[keyName]
+		if renderTemplateArg.IsAdmin && !rec.recording {
+			recRenderArg.DownloadLink = downloadPath(info)
+		}
+
 		var game gameInfoMetadata
-		err := rec.rec.RetrieveUserMetadata(&game)
-		info := rec.rec.RetrieveGameInfo()
+		if entry.Game != nil {
+			game = *entry.Game
+		}
 
 		recRenderArg.Recording = rec.recording
-		recRenderArg.Region = strings.ToUpper(platformToRegion[info.Platform])
+		if rec.recording && liveDelaySeconds > 0 {
+			recRenderArg.Live = true
+			if liveDelaySeconds%60 == 0 {
+				recRenderArg.LiveDelay = strconv.Itoa(
+					liveDelaySeconds/60) + " minute"
+			} else {
+				recRenderArg.LiveDelay = strconv.Itoa(
+					liveDelaySeconds) + " second"
+			}
+		}
+		recRenderArg.Region = strings.ToUpper(platformRegion(info.Platform))
 
-		duration := int(rec.rec.LastWriteTime().Sub(info.RecordTime).Minutes())
+		duration := int(entry.Duration.Minutes())
 
-		if err != nil {
+		if entry.Game == nil {
 			if rec.recording {
 				continue
 			}
 
-			log.Println("render: failed to get metadata for "+rec.location+":",
-				err)
+			log.Println("render: missing metadata for " + rec.location)
 			recRenderArg.NoMetadata = true
 			recRenderArg.Ago = capitalize(humanize.Time(
-				rec.rec.LastWriteTime()))
+				entry.LastWriteTime))
 			recRenderArg.Duration = strconv.Itoa(duration) + " minute"
 		} else {
 			recRenderArg.NoMetadata = false
 
 			if !rec.recording {
-				recRenderArg.IsComplete = rec.rec.IsComplete()
+				recRenderArg.IsComplete = entry.Complete
 			} else {
 				recRenderArg.IsComplete = true
 			}
@@ -194,16 +338,26 @@ func getRenderArg(r *http.Request, currentPage int) renderArg {
 			recRenderArg.Queue = getQueue(game.GameQueueConfigID)
 			recRenderArg.AQueue = aOrAn(recRenderArg.Queue)
 			recRenderArg.CapitalizedQueue = capitalize(recRenderArg.Queue)
-			recRenderArg.Ago = humanize.Time(rec.rec.LastWriteTime())
+			recRenderArg.Ago = humanize.Time(entry.LastWriteTime)
 			recRenderArg.Duration = strconv.Itoa(duration) + " minute"
 		}
 
-		host := strings.Split(r.Host, ":")
-
-		codeBody := host[0] + ":" + strconv.Itoa(config.ShowReplayPortAs) +
-			" " + info.EncryptionKey + " " + info.GameID + " " + info.Platform
-
-		recRenderArg.Code = "replay " + codeBody
+		recRenderArg.Code = replayString(r, info, "")
+		recRenderArg.LaunchLink = template.URL(launchLink(r, info))
+		recRenderArg.WindowsScript = launchScriptURL(info, ".bat")
+		recRenderArg.MacScript = launchScriptURL(info, ".command")
+
+		if !rec.recording {
+			step := startCodeInterval
+			for minute := step; minute < duration; minute += step {
+				recRenderArg.StartCodes = append(recRenderArg.StartCodes,
+					startCodeArg{
+						Label: strconv.Itoa(minute) + ":00",
+						Code: replayString(r, info,
+							replay.StartAtMinutePath(minute)),
+					})
+			}
+		}
 
 		staticDataMutex.Lock()
 		if staticDataAvailable {
@@ -211,8 +365,7 @@ func getRenderArg(r *http.Request, currentPage int) renderArg {
 			// Find people in the game
 			championsMutex.RLock()
 			for _, player := range game.Participants {
-				playerArgs, ok := getPlayerArg(player.SummonerID,
-					player.SummonerName, player.ChampionID, info)
+				playerArgs, ok := getPlayerArg(player, info)
 				if !ok {
 					continue
 				}
@@ -233,6 +386,77 @@ func getRenderArg(r *http.Request, currentPage int) renderArg {
 	return renderTemplateArg
 }
 
+// recordingDuration returns the length of a recording, preferring the
+// length of the game from the final game metadata if it is available.
+func recordingDuration(rec *recording.Recording) time.Duration {
+	meta, err := rec.RetrieveFinalGameMetadata()
+	if err == nil && meta.GameLength > 0 {
+		return time.Duration(meta.GameLength) * time.Millisecond
+	}
+
+	return rec.LastWriteTime().Sub(rec.RetrieveGameInfo().RecordTime)
+}
+
+// platformRegion returns the region to display for a platform.
+func platformRegion(platform string) string {
+	info, found := record.LookupPlatform(platform)
+	if !found {
+		return platform
+	}
+
+	return info.Region
+}
+
+// queueOptions returns the queues that recordings can be filtered by,
+// sorted by name. Some queues share a name, so the queue ID is included in
+// the name.
+func queueOptions() []queueOption {
+	ids := make([]int, 0, len(allQueues))
+	for id := range allQueues {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := strings.ToLower(allQueues[ids[i]]),
+			strings.ToLower(allQueues[ids[j]])
+		if a == b {
+			return ids[i] < ids[j]
+		}
+		return a < b
+	})
+
+	options := make([]queueOption, 0, len(ids))
+	for _, id := range ids {
+		options = append(options, queueOption{strconv.Itoa(id),
+			capitalize(allQueues[id]) + " (" + strconv.Itoa(id) + ")"})
+	}
+
+	return options
+}
+
+func platformIDs() []string {
+	var ids []string
+	for _, platform := range record.Platforms() {
+		ids = append(ids, platform.ID)
+	}
+
+	return ids
+}
+
+// championNames returns the names of all champions, sorted by name.
+func championNames() []string {
+	championsMutex.RLock()
+	defer championsMutex.RUnlock()
+
+	names := make([]string, 0, len(allChampions))
+	for _, champion := range allChampions {
+		names = append(names, champion.Name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
 func makePages(numPages int) []int {
 	result := make([]int, numPages)
 	for i := 1; i <= numPages; i++ {
@@ -323,6 +547,82 @@ var pageSource = `<!DOCTYPE html>
 </section>
 <section class="section">
 	<div class="container">
+		<form class="filter" method="get" action="/">
+			<p class="control is-grouped">
+				<input class="input" type="text" name="summoner" placeholder="Summoner" value="{{.Filter.Summoner}}">
+				<input class="input" type="text" name="champion" placeholder="Champion" list="champions" value="{{.Filter.Champion}}">
+				<datalist id="champions">
+					{{- range .Champions}}
+					<option value="{{.}}">
+					{{- end}}
+				</datalist>
+				<span class="select">
+					<select name="queue">
+						<option value="">Any queue</option>
+						{{- range .Queues}}
+						<option value="{{.ID}}"{{if eq .ID $.Filter.Queue}} selected{{end}}>{{.Name}}</option>
+						{{- end}}
+					</select>
+				</span>
+				<span class="select">
+					<select name="platform">
+						<option value="">Any platform</option>
+						{{- range .Platforms}}
+						<option value="{{.}}"{{if eq . $.Filter.Platform}} selected{{end}}>{{.}}</option>
+						{{- end}}
+					</select>
+				</span>
+			</p>
+			<p class="control is-grouped">
+				<input class="input" type="date" name="from" title="Recorded from" value="{{.Filter.From}}">
+				<input class="input" type="date" name="to" title="Recorded until" value="{{.Filter.To}}">
+				<span class="select">
+					<select name="status">
+						<option value="">Any status</option>
+						<option value="recording"{{if eq .Filter.Status "recording"}} selected{{end}}>Being recorded</option>
+						<option value="complete"{{if eq .Filter.Status "complete"}} selected{{end}}>Complete</option>
+						<option value="incomplete"{{if eq .Filter.Status "incomplete"}} selected{{end}}>Incomplete</option>
+					</select>
+				</span>
+				<button class="button is-primary" type="submit">Search</button>
+				{{- if .Query}}
+				<a class="button" href="/">Clear</a>
+				{{- end}}
+			</p>
+		</form>
+		{{- if .IsAdmin}}
+		<form class="record" onsubmit="return recordGame(this)">
+			<p class="control is-grouped">
+				<span class="select">
+					<select name="platform">
+						{{- range .Platforms}}
+						<option value="{{.}}">{{.}}</option>
+						{{- end}}
+					</select>
+				</span>
+				<input class="input" type="text" name="riot_id" placeholder="Riot ID (Name#TAG)">
+				<input class="input" type="text" name="game_id" placeholder="or game ID">
+				<input class="input" type="text" name="encryption_key" placeholder="and encryption key">
+				<button class="button" type="submit">Record</button>
+			</p>
+			<div class="notification" style="display:none;"></div>
+		</form>
+		{{- end}}
+		{{- if .FilterError}}
+		<div class="notification is-danger">{{.FilterError}}.</div>
+		{{- else if and .Query (not .Recordings)}}
+		<div class="notification">No recordings match your search.</div>
+		{{- end}}
+		{{- if .Removals}}
+		<div class="notification is-warning">
+			<p>The next cleanup will remove {{len .Removals}} recording{{if ne (len .Removals) 1}}s{{end}} ({{.RemovalSize}}):</p>
+			<ul>
+				{{- range .Removals}}
+				<li><a href="/?game={{.Key}}">{{.Key}}</a>: {{.Reason}}</li>
+				{{- end}}
+			</ul>
+		</div>
+		{{- end}}
 		<div class="masonry">
 			{{- range $recording := .Recordings}}
 			<div class="masonry-half">
@@ -373,15 +673,43 @@ var pageSource = `<!DOCTYPE html>
 						{{- else}}
 						<p>A {{.Duration}} game played {{.Ago}} on {{.Region}}.</p>
 						{{- end}}
-						{{- if not .Recording}}
+						{{- if .Visibility}}
+						<p class="visibility">{{.Visibility}} recording (<a href="{{.ShareLink}}">link</a>)</p>
+						{{- end}}
+						{{- if .Pinned}}
+						<p class="pinned">Pinned, and never removed by the retention policy.</p>
+						{{- else if .Removal}}
+						<p class="removal">Will be removed by the next cleanup: {{.Removal}}.</p>
+						{{- end}}
+						{{- if .Live}}
+						<p>Watch it live with a {{.LiveDelay}} delay:</p>
+						{{- end}}
+						{{- if or (not .Recording) .Live}}
 						<div class="code-area">
 							<textarea readonly>{{.Code}}</textarea>
 						</div>
+						{{- if .StartCodes}}
+						<p class="start-codes">Watch from:
+							<a onclick="setCode(this)" data-code="{{.Code}}">start</a>
+							{{- range .StartCodes}}
+							<a onclick="setCode(this)" data-code="{{.Code}}">{{.Label}}</a>
+							{{- end}}
+						</p>
+						{{- end}}
 						{{- end}}
 					</div>
 					<footer class="card-footer">
-						{{- if not .Recording}}
+						{{- if or (not .Recording) .Live}}
 						<a class="card-footer-item" onclick="copyCode(this)">Copy to clipboard</a>
+						<a class="card-footer-item" href="{{.WindowsScript}}" download>Windows</a>
+						<a class="card-footer-item" href="{{.MacScript}}" download>macOS</a>
+						<a class="card-footer-item" href="{{.LaunchLink}}">Open</a>
+						{{- end}}
+						{{- if .DownloadLink}}
+						<a class="card-footer-item" href="{{.DownloadLink}}" download>Download</a>
+						{{- end}}
+						{{- if $.IsAdmin}}
+						<a class="card-footer-item" onclick="setPinned({{.Key}}, {{not .Pinned}})">{{if .Pinned}}Unpin{{else}}Pin{{end}}</a>
 						{{- end}}
 					</footer>
 				</div>
@@ -394,15 +722,15 @@ var pageSource = `<!DOCTYPE html>
 			<a disabled>Previous</a>
 			{{- else}}
 			{{- if eq .PreviousPage 1}}
-			<a href="/">Previous</a>
+			<a href="/{{.Query}}">Previous</a>
 			{{- else}}
-			<a href="/{{.PreviousPage}}">Previous</a>
+			<a href="/{{.PreviousPage}}{{.Query}}">Previous</a>
 			{{- end}}
 			{{- end}}
-			{{- if eq .CurrentPage (len .Pages)}}
+			{{- if ge .CurrentPage (len .Pages)}}
 			<a disabled>Next</a>
 			{{- else}}
-			<a href="/{{.NextPage}}">Next</a>
+			<a href="/{{.NextPage}}{{.Query}}">Next</a>
 			{{- end}}
 			<ul>
 				{{- range $element := .Pages}}
@@ -411,9 +739,9 @@ var pageSource = `<!DOCTYPE html>
 						<a class="is-active">{{.}}</a>
 						{{- else}}
 						{{- if eq . 1}}
-						<a href="/">{{.}}</a>
+						<a href="/{{$.Query}}">{{.}}</a>
 						{{- else}}
-						<a href="/{{.}}">{{.}}</a>
+						<a href="/{{.}}{{$.Query}}">{{.}}</a>
 						{{- end}}
 						{{- end}}
 					</li>
@@ -438,6 +766,7 @@ var pageSource = `<!DOCTYPE html>
 	</div>
 </footer>
 <script type="text/javascript">
+var csrfToken = {{.CSRFToken}};
 var masonry = document.getElementsByClassName("masonry");
 
 var leftColumnHeight = 0
@@ -453,6 +782,16 @@ for (var i = 0; i < masonry[0].children.length; i++) {
 	}
 }
 
+var setCode = function(elem) {
+	var cardContent = elem.parentElement.parentElement;
+	for (var i = 0; i < cardContent.children.length; i++) {
+		if (cardContent.children[i].className == "code-area") {
+			cardContent.children[i].children[0].value = elem.getAttribute("data-code");
+			break;
+		}
+	}
+}
+
 var copyCode = function(elem) {
 	if (elem.innerText != "Copy to clipboard") {
 		return;
@@ -488,6 +827,48 @@ var copyCode = function(elem) {
 	}
 }
 
+var recordGame = function(form) {
+	var body = {"platform": form.platform.value};
+	if (form.game_id.value) {
+		body.game_id = form.game_id.value;
+		body.encryption_key = form.encryption_key.value;
+	} else {
+		body.riot_id = form.riot_id.value;
+	}
+
+	var notification = form.getElementsByClassName("notification")[0];
+	var request = new XMLHttpRequest();
+	request.open("POST", "/api/v1/record");
+	request.setRequestHeader("Content-Type", "application/json");
+	request.setRequestHeader("X-CSRF-Token", csrfToken);
+	request.onload = function() {
+		var response = JSON.parse(request.responseText);
+		notification.style.display = "";
+		if (request.status == 202) {
+			notification.className = "notification is-success";
+			notification.innerText = "Recording " + response.key + ".";
+		} else {
+			notification.className = "notification is-danger";
+			notification.innerText = response.error.charAt(0).toUpperCase() +
+				response.error.slice(1) + ".";
+		}
+	};
+	request.send(JSON.stringify(body));
+	return false;
+}
+
+var setPinned = function(key, pinned) {
+	var parts = key.split("_");
+	var request = new XMLHttpRequest();
+	request.open("PATCH", "/api/v1/recordings/" + parts[0] + "/" + parts[1]);
+	request.setRequestHeader("Content-Type", "application/json");
+	request.setRequestHeader("X-CSRF-Token", csrfToken);
+	request.onload = function() {
+		window.location.reload();
+	};
+	request.send(JSON.stringify({"pinned": pinned}));
+}
+
 var flashButton = function(elem, success) {
 	if (success) {
 		try {